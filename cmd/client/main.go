@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+const (
+	retryBaseDelay   = 20 * time.Millisecond
+	fileWriteTimeout = time.Second
+)
+
+type options struct {
+	url       string
+	timeout   time.Duration
+	retries   int
+	output    string
+	format    string
+	jsonKey   string
+	decimals  int
+	watch     bool
+	interval  time.Duration
+	transport transportOptions
+}
+
+// transportOptions tunes the connections the client keeps to the server.
+type transportOptions struct {
+	dialTimeout time.Duration
+	keepAlive   time.Duration
+	idleTimeout time.Duration
+	maxIdle     int
+	http2       bool
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.url, "url", "http://localhost:8080/cotacao", "cotacao endpoint of the server")
+	flag.DurationVar(&opts.timeout, "timeout", cotacao.DefaultClientTimeout, "deadline for fetching the cotacao, retries included")
+	flag.IntVar(&opts.retries, "retries", 2, "times a request is retried while the server is unreachable or failing")
+	flag.StringVar(&opts.output, "output", "cotacao.txt", "file the cotacao is written to")
+	flag.StringVar(&opts.format, "format", "text", "output format: text, json or csv (csv appends a row)")
+	flag.StringVar(&opts.jsonKey, "json-key", "bid", "key the bid is written under with -format=json")
+	flag.IntVar(&opts.decimals, "decimals", cotacao.DefaultBidScale, "decimal places the bid is written with")
+	flag.DurationVar(&opts.transport.dialTimeout, "dial-timeout", 100*time.Millisecond, "deadline for opening a connection to the server")
+	flag.DurationVar(&opts.transport.keepAlive, "keep-alive", 30*time.Second, "TCP keep-alive period, negative to disable")
+	flag.DurationVar(&opts.transport.idleTimeout, "idle-timeout", 90*time.Second, "how long an idle connection is kept for reuse")
+	flag.IntVar(&opts.transport.maxIdle, "max-idle-conns", 2, "idle connections kept per host")
+	flag.BoolVar(&opts.transport.http2, "http2", true, "try HTTP/2 when the server supports it")
+	flag.BoolVar(&opts.watch, "watch", false, "keep fetching every -interval until interrupted; requires -format=csv")
+	flag.DurationVar(&opts.interval, "interval", 5*time.Second, "time between fetches with -watch")
+	flag.Parse()
+
+	if err := run(opts); err != nil {
+		log.Printf("Error %v", err)
+	}
+}
+
+// timeoutWarning explains why timeout is too short for the server's budget,
+// or returns "" when it is long enough.
+func timeoutWarning(timeout time.Duration) string {
+	if timeout >= cotacao.MinClientTimeout {
+		return ""
+	}
+	return fmt.Sprintf("Warning: timeout %s is shorter than the server's fetch and save budget of %s; requests may time out", timeout, cotacao.MinClientTimeout)
+}
+
+// run fetches the bid from opts.url and writes it to opts.output, once or,
+// with opts.watch, every opts.interval.
+func run(opts options) error {
+	if warning := timeoutWarning(opts.timeout); warning != "" {
+		log.Print(warning)
+	}
+
+	if opts.decimals < 0 {
+		return fmt.Errorf("-decimals must be >= 0, got %d", opts.decimals)
+	}
+	if opts.retries < 0 {
+		return fmt.Errorf("-retries must be >= 0, got %d", opts.retries)
+	}
+
+	client := newHTTPClient(opts.transport)
+	if !opts.watch {
+		return fetchAndSave(context.Background(), client, opts)
+	}
+
+	if opts.format != "csv" {
+		return fmt.Errorf("-watch appends rows and requires -format=csv, got %q", opts.format)
+	}
+	if opts.interval <= 0 {
+		return fmt.Errorf("-interval must be positive, got %s", opts.interval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return watch(ctx, client, opts)
+}
+
+// watch fetches the bid every opts.interval until ctx is done, appending a
+// row to opts.output each time. The file stays open for the whole loop and
+// each row is flushed and synced before the next fetch, so an interrupt never
+// loses a row that was already written. Failed iterations are logged and the
+// loop goes on.
+func watch(ctx context.Context, client *http.Client, opts options) (err error) {
+	out, err := openCSVAppender(opts.output)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", opts.output, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("closing %s: %w", opts.output, closeErr)
+		}
+	}()
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fetchAndAppend(ctx, client, opts, out); err != nil && ctx.Err() == nil {
+			log.Printf("Error %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Print("Stopping watch")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchAndAppend fetches the bid once and appends it to out.
+func fetchAndAppend(ctx context.Context, client *http.Client, opts options, out *csvAppender) error {
+	bid, err := fetchBid(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+	if err := out.Append(time.Now(), bid); err != nil {
+		return fmt.Errorf("saving dolar price on file: %w", err)
+	}
+
+	log.Printf("Dolar price saved successfully on %s file", opts.output)
+	return nil
+}
+
+// fetchAndSave fetches the bid once and writes it to opts.output.
+func fetchAndSave(ctx context.Context, client *http.Client, opts options) error {
+	bid, err := fetchBid(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+
+	writeCtx, writeCancel := context.WithTimeout(context.Background(), fileWriteTimeout)
+	defer writeCancel()
+
+	if err := saveCotacaoToFile(writeCtx, opts.output, bid, opts.format, opts.jsonKey); err != nil {
+		return fmt.Errorf("saving dolar price on file: %w", err)
+	}
+
+	log.Printf("Dolar price saved successfully on %s file", opts.output)
+	return nil
+}
+
+// fetchBid fetches the bid within opts.timeout and normalizes it to
+// opts.decimals places.
+func fetchBid(ctx context.Context, client *http.Client, opts options) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	correlationID := uuid.NewString()
+	log.Printf("Correlation ID: %s", correlationID)
+
+	received, err := fetchCotacao(ctx, client, opts.url, opts.retries, correlationID)
+	if err != nil {
+		return "", fmt.Errorf("fetching dolar price: %w", err)
+	}
+	bid, err := cotacao.NormalizeBid(received, int32(opts.decimals))
+	if err != nil {
+		return "", fmt.Errorf("validating dolar price: %w", err)
+	}
+
+	fmt.Printf("Dolar price: %s\n", bid)
+	return bid, nil
+}
+
+// newHTTPClient returns a client whose transport follows opts. Sharing it
+// across requests lets them reuse its connections.
+func newHTTPClient(opts transportOptions) *http.Client {
+	dialer := &net.Dialer{Timeout: opts.dialTimeout, KeepAlive: opts.keepAlive}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   opts.http2,
+			MaxIdleConnsPerHost: opts.maxIdle,
+			IdleConnTimeout:     opts.idleTimeout,
+		},
+	}
+}
+
+// fetchCotacao requests the bid from url through client, retrying up to
+// retries times with exponential backoff while the server is unreachable or
+// failing. The overall deadline is taken from ctx. Every attempt carries
+// correlationID.
+func fetchCotacao(ctx context.Context, client *http.Client, url string, retries int, correlationID string) (string, error) {
+	var lastErr error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			timer := time.NewTimer(retryBaseDelay << (i - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return "", fmt.Errorf("%w (last error: %v)", ctx.Err(), lastErr)
+			case <-timer.C:
+			}
+		}
+
+		bid, retryable, err := requestCotacao(ctx, client, url, correlationID)
+		if err == nil {
+			return bid, nil
+		}
+		lastErr = err
+		if !retryable {
+			return "", err
+		}
+		log.Printf("Request attempt %d failed: %v", i+1, err)
+	}
+
+	return "", lastErr
+}
+
+func requestCotacao(ctx context.Context, client *http.Client, url, correlationID string) (bid string, retryable bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("creating the request: %w", err)
+	}
+	req.Header.Set(cotacao.CorrelationIDHeader, correlationID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", ctx.Err() == nil, fmt.Errorf("doing request: %w", err)
+	}
+	// Draining the body lets the transport reuse the connection.
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", resp.StatusCode >= 500, fmt.Errorf("HTTP status %d", resp.StatusCode)
+	}
+
+	var response cotacao.CotacaoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", false, fmt.Errorf("decoding the JSON response: %w", err)
+	}
+
+	return response.Bid, false, nil
+}
+
+// saveCotacaoToFile serializes bid in the given format and writes it to path.
+// The csv format appends a row to the existing file instead of replacing it;
+// the json format stores bid under jsonKey.
+func saveCotacaoToFile(ctx context.Context, path string, bid string, format string, jsonKey string) error {
+	var previous []byte
+	if format == "csv" {
+		var err error
+		previous, err = os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	content, err := serializeCotacao(format, bid, jsonKey, time.Now(), previous)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ctx, path, content)
+}
+
+func serializeCotacao(format string, bid string, jsonKey string, now time.Time, previous []byte) ([]byte, error) {
+	switch format {
+	case "text":
+		return []byte(fmt.Sprintf("Dólar: %s", bid)), nil
+	case "json":
+		return json.Marshal(map[string]string{jsonKey: bid})
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if len(previous) == 0 {
+			w.Write([]string{"timestamp", "bid"})
+		} else {
+			buf.Write(previous)
+			if !bytes.HasSuffix(previous, []byte("\n")) {
+				buf.WriteByte('\n')
+			}
+		}
+		w.Write([]string{now.Format(time.RFC3339), bid})
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// csvAppender appends timestamp,bid rows to a file it keeps open. The
+// csv.Writer buffers each row until Append flushes it.
+type csvAppender struct {
+	file *os.File
+	w    *csv.Writer
+}
+
+// openCSVAppender opens path for appending, writing the header when the file
+// is new and terminating a last line that lacks its newline.
+func openCSVAppender(path string) (*csvAppender, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := &csvAppender{file: file, w: csv.NewWriter(file)}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		a.w.Write([]string{"timestamp", "bid"})
+		err = a.flush()
+	} else {
+		last := make([]byte, 1)
+		if _, err = file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			_, err = file.Write([]byte{'\n'})
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Append writes a row and flushes it to disk before returning.
+func (a *csvAppender) Append(now time.Time, bid string) error {
+	a.w.Write([]string{now.Format(time.RFC3339), bid})
+	return a.flush()
+}
+
+func (a *csvAppender) flush() error {
+	a.w.Flush()
+	if err := a.w.Error(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close flushes anything still buffered and closes the file.
+func (a *csvAppender) Close() error {
+	flushErr := a.flush()
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and renames
+// it over path, so readers never observe a partially written file. The rename
+// is skipped when ctx is done by then.
+func writeFileAtomic(ctx context.Context, path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
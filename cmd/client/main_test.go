@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+// cotacaoServer serves the real /cotacao handler over stubbed fetcher and
+// repository.
+func cotacaoServer(t *testing.T, bid string) *httptest.Server {
+	t.Helper()
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: bid}, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+	srv := httptest.NewServer(http.HandlerFunc(server.CotacaoHandler))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchCotacaoRoundTrip(t *testing.T) {
+	srv := cotacaoServer(t, "5.4321")
+
+	bid, err := fetchCotacao(context.Background(), srv.Client(), srv.URL, 0, "round-trip")
+	if err != nil {
+		t.Fatalf("fetchCotacao: %v", err)
+	}
+	if bid != "5.4321" {
+		t.Errorf("bid = %q, want 5.4321 as served", bid)
+	}
+}
+
+func TestFetchCotacaoSendsCorrelationID(t *testing.T) {
+	var seen []string
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.4321"}, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+	srv := httptest.NewServer(cotacao.WithCorrelationID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = append(seen, cotacao.CorrelationIDFromContext(r.Context()))
+		server.CotacaoHandler(w, r)
+	})))
+	t.Cleanup(srv.Close)
+
+	if _, err := fetchCotacao(context.Background(), srv.Client(), srv.URL, 0, "corr-123"); err != nil {
+		t.Fatalf("fetchCotacao: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != "corr-123" {
+		t.Errorf("server saw correlation IDs %q, want [corr-123]", seen)
+	}
+
+	opts := options{url: srv.URL, timeout: time.Second, decimals: cotacao.DefaultBidScale}
+	for i := 0; i < 2; i++ {
+		if _, err := fetchBid(context.Background(), srv.Client(), opts); err != nil {
+			t.Fatalf("fetchBid: %v", err)
+		}
+	}
+	if len(seen) != 3 || seen[1] == "" || seen[1] == seen[2] {
+		t.Errorf("server saw correlation IDs %q, want a fresh one generated per fetchBid", seen)
+	}
+}
+
+func TestNewHTTPClientReusesConnections(t *testing.T) {
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.4321"}, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(server.CotacaoHandler))
+	var conns atomic.Int32
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	client := newHTTPClient(transportOptions{dialTimeout: time.Second, keepAlive: 30 * time.Second, idleTimeout: time.Minute, maxIdle: 2, http2: true})
+
+	for i := 0; i < 2; i++ {
+		if _, err := fetchCotacao(context.Background(), client, srv.URL, 0, "reuse"); err != nil {
+			t.Fatalf("request %d: fetchCotacao: %v", i+1, err)
+		}
+	}
+
+	if n := conns.Load(); n != 1 {
+		t.Errorf("opened %d connections for two sequential requests, want 1 reused", n)
+	}
+}
+
+func TestFetchAndSaveUsesURLAndOutput(t *testing.T) {
+	srv := cotacaoServer(t, "5.4321")
+	path := filepath.Join(t.TempDir(), "out.txt")
+	opts := options{url: srv.URL, timeout: time.Second, output: path, format: "text", jsonKey: "bid", decimals: cotacao.DefaultBidScale}
+
+	if err := fetchAndSave(context.Background(), srv.Client(), opts); err != nil {
+		t.Fatalf("fetchAndSave: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	if got, want := string(content), "Dólar: 5.4321"; got != want {
+		t.Errorf("file contents = %q, want %q", got, want)
+	}
+}
+
+func TestFetchAndSaveValidatesBid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"valid", `{"bid":"5.43"}`, "Dólar: 5.4300"},
+		{"missing", `{}`, ""},
+		{"non-numeric", `{"bid":"N/A"}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+			path := filepath.Join(t.TempDir(), "out.txt")
+			opts := options{url: srv.URL, timeout: time.Second, output: path, format: "text", jsonKey: "bid", decimals: cotacao.DefaultBidScale}
+
+			err := fetchAndSave(context.Background(), srv.Client(), opts)
+
+			content, readErr := os.ReadFile(path)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("fetchAndSave accepted %s", tt.body)
+				}
+				if !errors.Is(readErr, fs.ErrNotExist) {
+					t.Errorf("wrote %q for %s, want no file", content, tt.body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchAndSave: %v", err)
+			}
+			if got := string(content); got != tt.want {
+				t.Errorf("file contents = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeoutWarning(t *testing.T) {
+	tests := []struct {
+		timeout  time.Duration
+		wantWarn bool
+	}{
+		{time.Millisecond, true},
+		{cotacao.MinClientTimeout - time.Millisecond, true},
+		{cotacao.MinClientTimeout, false},
+		{cotacao.DefaultClientTimeout, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
+			warning := timeoutWarning(tt.timeout)
+			if (warning != "") != tt.wantWarn {
+				t.Errorf("timeoutWarning(%s) = %q, want a warning %v", tt.timeout, warning, tt.wantWarn)
+			}
+			if tt.wantWarn && !strings.Contains(warning, cotacao.MinClientTimeout.String()) {
+				t.Errorf("warning %q does not mention the %s minimum", warning, cotacao.MinClientTimeout)
+			}
+		})
+	}
+	if cotacao.DefaultClientTimeout < cotacao.MinClientTimeout {
+		t.Errorf("DefaultClientTimeout %s is below MinClientTimeout %s", cotacao.DefaultClientTimeout, cotacao.MinClientTimeout)
+	}
+}
+
+func TestSaveCotacaoToFileText(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cotacao.txt")
+
+	if err := saveCotacaoToFile(context.Background(), path, "5.4321", "text", "bid"); err != nil {
+		t.Fatalf("saveCotacaoToFile: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	if got, want := string(content), "Dólar: 5.4321"; got != want {
+		t.Errorf("file contents = %q, want %q", got, want)
+	}
+}
+
+func TestFetchCotacaoRetriesUntilSuccess(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(cotacao.CotacaoResponse{Bid: "5.4321"})
+	}))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bid, err := fetchCotacao(ctx, srv.Client(), srv.URL, 2, "retry")
+
+	if err != nil {
+		t.Fatalf("fetchCotacao: %v", err)
+	}
+	if bid != "5.4321" || hits.Load() != 3 {
+		t.Errorf("got %q after %d requests, want 5.4321 on the third", bid, hits.Load())
+	}
+}
+
+func TestFetchCotacaoGivesUpAfterRetries(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	if _, err := fetchCotacao(context.Background(), srv.Client(), srv.URL, 1, "retry"); err == nil {
+		t.Fatal("fetchCotacao succeeded against a failing server")
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("server hit %d times, want 2 (one retry)", n)
+	}
+}
+
+func TestFetchBidUsesRetriesOption(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	opts := options{url: srv.URL, timeout: 5 * time.Second, retries: 0, decimals: cotacao.DefaultBidScale}
+	if _, err := fetchBid(context.Background(), srv.Client(), opts); err == nil {
+		t.Fatal("fetchBid succeeded against a failing server")
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("server hit %d times with -retries=0, want 1", n)
+	}
+}
+
+func TestWriteFileAtomicReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cotacao.txt")
+	if err := os.WriteFile(path, []byte("Dólar: 1.0000"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(context.Background(), path, []byte("Dólar: 5.4321")); err != nil {
+		t.Fatalf("writeFileAtomic: %v", err)
+	}
+
+	if content, err := os.ReadFile(path); err != nil || string(content) != "Dólar: 5.4321" {
+		t.Errorf("file = %q, %v; want the new contents", content, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only cotacao.txt without temp files", len(entries))
+	}
+}
+
+func TestWriteFileAtomicCancelled(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cotacao.txt")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := writeFileAtomic(ctx, path, []byte("Dólar: 5.4321")); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("directory holds %d entries after a cancelled write, want none", len(entries))
+	}
+}
+
+func TestSaveCotacaoToFileJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cotacao.json")
+
+	if err := saveCotacaoToFile(context.Background(), path, "5.4321", "json", "dolar"); err != nil {
+		t.Fatalf("saveCotacaoToFile: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(content, &got); err != nil || len(got) != 1 || got["dolar"] != "5.4321" {
+		t.Errorf("file = %s, want {\"dolar\":\"5.4321\"}", content)
+	}
+}
+
+func TestFetchAndSaveJSONKey(t *testing.T) {
+	srv := cotacaoServer(t, "5.4321")
+
+	for _, key := range []string{"bid", "dolar", "usd_brl", "valor do dólar"} {
+		t.Run(key, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cotacao.json")
+			opts := options{url: srv.URL, timeout: time.Second, output: path, format: "json", jsonKey: key, decimals: cotacao.DefaultBidScale}
+
+			if err := fetchAndSave(context.Background(), srv.Client(), opts); err != nil {
+				t.Fatalf("fetchAndSave: %v", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("reading %s: %v", path, err)
+			}
+			var got map[string]string
+			if err := json.Unmarshal(content, &got); err != nil || len(got) != 1 || got[key] != "5.4321" {
+				t.Errorf("file = %s, want only %q set to 5.4321", content, key)
+			}
+		})
+	}
+}
+
+func TestSaveCotacaoToFileCSVAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cotacao.csv")
+
+	for _, bid := range []string{"5.4321", "5.6789"} {
+		if err := saveCotacaoToFile(context.Background(), path, bid, "csv", "bid"); err != nil {
+			t.Fatalf("saveCotacaoToFile(%s): %v", bid, err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("reading %s: %v", path, err)
+	}
+	if len(records) != 3 || records[0][0] != "timestamp" || records[0][1] != "bid" {
+		t.Fatalf("records = %v, want a header and two rows", records)
+	}
+	for i, want := range []string{"5.4321", "5.6789"} {
+		row := records[i+1]
+		if _, err := time.Parse(time.RFC3339, row[0]); err != nil || row[1] != want {
+			t.Errorf("row %d = %v, want an RFC 3339 timestamp and %s", i+1, row, want)
+		}
+	}
+}
+
+func TestWatchAppendsRowPerInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		if n > 2 {
+			// Interrupt the watch while the third fetch is in flight.
+			cancel()
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(cotacao.CotacaoResponse{Bid: fmt.Sprintf("5.000%d", n)})
+	}))
+	t.Cleanup(srv.Close)
+	path := filepath.Join(t.TempDir(), "cotacao.csv")
+	opts := options{url: srv.URL, timeout: time.Second, output: path, format: "csv", decimals: cotacao.DefaultBidScale, interval: 10 * time.Millisecond}
+
+	if err := watch(ctx, srv.Client(), opts); err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	if len(records) != 3 || records[0][1] != "bid" || records[1][1] != "5.0001" || records[2][1] != "5.0002" {
+		t.Fatalf("records = %v, want the header and rows for 5.0001 and 5.0002", records)
+	}
+	first, err1 := time.Parse(time.RFC3339, records[1][0])
+	second, err2 := time.Parse(time.RFC3339, records[2][0])
+	if err1 != nil || err2 != nil || second.Before(first) {
+		t.Errorf("timestamps %q, %q; want RFC3339 in order", records[1][0], records[2][0])
+	}
+}
+
+func TestRunWatchKeepsRowsOnInterrupt(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		if n > 2 {
+			// Interrupt the process while the third fetch is in flight, as
+			// Ctrl-C would.
+			process, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				err = process.Signal(os.Interrupt)
+			}
+			if err != nil {
+				t.Errorf("sending SIGINT: %v", err)
+			}
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(cotacao.CotacaoResponse{Bid: fmt.Sprintf("5.000%d", n)})
+	}))
+	t.Cleanup(srv.Close)
+	path := filepath.Join(t.TempDir(), "cotacao.csv")
+	opts := options{url: srv.URL, timeout: time.Second, output: path, format: "csv", decimals: cotacao.DefaultBidScale, watch: true, interval: 10 * time.Millisecond}
+
+	if err := run(opts); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(content), "5.0002\n") {
+		t.Fatalf("file = %q, want it to end with the complete 5.0002 row", content)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(content))).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Errorf("records = %v, %v; want the header and two rows", records, err)
+	}
+}
+
+func TestSaveCotacaoToFileUnknownFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cotacao.xml")
+
+	if err := saveCotacaoToFile(context.Background(), path, "5.4321", "xml", "bid"); err == nil {
+		t.Error("saveCotacaoToFile accepted format xml")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat %s = %v, want no file for an unknown format", path, err)
+	}
+}
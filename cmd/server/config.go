@@ -0,0 +1,449 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+type Config struct {
+	URL                string
+	BidPath            string
+	StrictDecode       bool
+	Headers            http.Header
+	File               string
+	Retry              int
+	RetryMaxElapsed    time.Duration
+	FailureThreshold   int
+	CategoryThresholds map[cotacao.FailureCategory]int
+	ResetTime          time.Duration
+	Fallback           string
+	FallbackFiles      []string
+	MaxBodySize        int64
+	AllowInjection     bool
+	DryRun             bool
+	Dedupe             bool
+	TolerateSaveErrors bool
+	LegacyResponse     bool
+	AsyncWrites        bool
+	WriteQueueSize     int
+	WriteFlush         time.Duration
+	BidScale           int
+	FallbackPolicy     cotacao.FallbackPolicy
+	ListenAddr         string
+	TLSCertFile        string
+	TLSKeyFile         string
+	CORSOrigins        []string
+	ShutdownTimeout    time.Duration
+	RequestTimeout     time.Duration
+	CacheTTL           time.Duration
+	FetchConcurrency   int
+	FetchWait          bool
+	GzipMinSize        int
+	StreamInterval     time.Duration
+	RefreshInterval    time.Duration
+	PruneInterval      time.Duration
+	Retention          time.Duration
+	RateLimitRPS       float64
+	RateLimitBurst     int
+	IPRateLimitRPS     float64
+	IPRateLimitBurst   int
+	TrustedProxies     []string
+	MetricsPrefix      string
+	LogFormat          string
+	LogLevel           slog.Level
+	DBDriver           string
+	DBDriverName       string
+	DBPath             string
+	DatabaseURL        string
+	DBPool             cotacao.PoolConfig
+}
+
+func DefaultConfig() Config {
+	return Config{
+		URL:              "https://economia.awesomeapi.com.br/json/last/",
+		Retry:            3,
+		FailureThreshold: 2,
+		ResetTime:        2 * time.Second,
+		Fallback:         "1.00",
+		MaxBodySize:      cotacao.DefaultMaxBodySize,
+		BidScale:         4,
+		WriteQueueSize:   1000,
+		WriteFlush:       100 * time.Millisecond,
+		ListenAddr:       ":8080",
+		ShutdownTimeout:  5 * time.Second,
+		StreamInterval:   5 * time.Second,
+		GzipMinSize:      1024,
+		FetchWait:        true,
+		PruneInterval:    time.Hour,
+		RateLimitBurst:   1,
+		IPRateLimitBurst: 1,
+		MetricsPrefix:    "cotacao",
+		LogFormat:        "text",
+		LogLevel:         slog.LevelInfo,
+		DBDriver:         "sqlite3",
+		DBPath:           "./cotacao.db",
+		DBPool:           cotacao.DefaultPoolConfig(),
+	}
+}
+
+// LoadConfig reads the configuration through getenv (usually os.Getenv),
+// keeping the defaults for unset variables.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	cfg := DefaultConfig()
+	var err error
+
+	stringFromEnv(getenv, "COTACAO_URL", &cfg.URL)
+	stringFromEnv(getenv, "COTACAO_BID_PATH", &cfg.BidPath)
+	stringFromEnv(getenv, "COTACAO_FILE", &cfg.File)
+	cfg.FallbackFiles = listFromEnv(getenv, "COTACAO_FALLBACK_FILES")
+	if cfg.Headers, err = parseHeaders(listFromEnv(getenv, "COTACAO_HEADERS")); err != nil {
+		return Config{}, err
+	}
+	if key := getenv("COTACAO_API_KEY"); key != "" {
+		name := getenv("COTACAO_API_KEY_HEADER")
+		if name == "" {
+			name = "X-API-Key"
+		}
+		cfg.Headers.Set(name, key)
+	}
+	stringFromEnv(getenv, "COTACAO_FALLBACK", &cfg.Fallback)
+	stringFromEnv(getenv, "LISTEN_ADDR", &cfg.ListenAddr)
+	stringFromEnv(getenv, "TLS_CERT_FILE", &cfg.TLSCertFile)
+	stringFromEnv(getenv, "TLS_KEY_FILE", &cfg.TLSKeyFile)
+	cfg.CORSOrigins = listFromEnv(getenv, "CORS_ALLOWED_ORIGINS")
+	cfg.TrustedProxies = listFromEnv(getenv, "TRUSTED_PROXIES")
+	stringFromEnv(getenv, "METRICS_PREFIX", &cfg.MetricsPrefix)
+	stringFromEnv(getenv, "LOG_FORMAT", &cfg.LogFormat)
+	stringFromEnv(getenv, "DB_DRIVER", &cfg.DBDriver)
+	stringFromEnv(getenv, "DB_DRIVER_NAME", &cfg.DBDriverName)
+	stringFromEnv(getenv, "DB_PATH", &cfg.DBPath)
+	stringFromEnv(getenv, "DATABASE_URL", &cfg.DatabaseURL)
+
+	if v := getenv("ALLOW_INJECTION"); v != "" {
+		if cfg.AllowInjection, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid ALLOW_INJECTION %q: %w", v, err)
+		}
+	}
+	if v := getenv("DRY_RUN"); v != "" {
+		if cfg.DryRun, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid DRY_RUN %q: %w", v, err)
+		}
+	}
+	if v := getenv("DEDUPE"); v != "" {
+		if cfg.Dedupe, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid DEDUPE %q: %w", v, err)
+		}
+	}
+	if v := getenv("TOLERATE_SAVE_ERRORS"); v != "" {
+		if cfg.TolerateSaveErrors, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid TOLERATE_SAVE_ERRORS %q: %w", v, err)
+		}
+	}
+	if v := getenv("LEGACY_RESPONSE"); v != "" {
+		if cfg.LegacyResponse, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid LEGACY_RESPONSE %q: %w", v, err)
+		}
+	}
+	if v := getenv("FETCH_CONCURRENCY_WAIT"); v != "" {
+		if cfg.FetchWait, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid FETCH_CONCURRENCY_WAIT %q: %w", v, err)
+		}
+	}
+	if v := getenv("COTACAO_STRICT_DECODE"); v != "" {
+		if cfg.StrictDecode, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid COTACAO_STRICT_DECODE %q: %w", v, err)
+		}
+	}
+	if v := getenv("ASYNC_WRITES"); v != "" {
+		if cfg.AsyncWrites, err = strconv.ParseBool(v); err != nil {
+			return Config{}, fmt.Errorf("invalid ASYNC_WRITES %q: %w", v, err)
+		}
+	}
+	if v := getenv("COTACAO_FALLBACK_POLICY"); v != "" {
+		if cfg.FallbackPolicy, err = parseFallbackPolicy(v); err != nil {
+			return Config{}, err
+		}
+	}
+	if v := getenv("LOG_LEVEL"); v != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return Config{}, fmt.Errorf("invalid LOG_LEVEL %q: %w", v, err)
+		}
+	}
+	if cfg.Retry, err = intFromEnv(getenv, "COTACAO_RETRY", cfg.Retry); err != nil {
+		return Config{}, err
+	}
+	if cfg.RetryMaxElapsed, err = durationFromEnv(getenv, "COTACAO_RETRY_MAX_ELAPSED", cfg.RetryMaxElapsed); err != nil {
+		return Config{}, err
+	}
+	if cfg.FailureThreshold, err = intFromEnv(getenv, "COTACAO_FAILURE_THRESHOLD", cfg.FailureThreshold); err != nil {
+		return Config{}, err
+	}
+	if cfg.CategoryThresholds, err = parseCategoryThresholds(listFromEnv(getenv, "COTACAO_CATEGORY_THRESHOLDS")); err != nil {
+		return Config{}, err
+	}
+	if cfg.PruneInterval, err = durationFromEnv(getenv, "PRUNE_INTERVAL", cfg.PruneInterval); err != nil {
+		return Config{}, err
+	}
+	if cfg.Retention, err = durationFromEnv(getenv, "RETENTION", cfg.Retention); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimitRPS, err = floatFromEnv(getenv, "RATE_LIMIT_RPS", cfg.RateLimitRPS); err != nil {
+		return Config{}, err
+	}
+	if cfg.RateLimitBurst, err = intFromEnv(getenv, "RATE_LIMIT_BURST", cfg.RateLimitBurst); err != nil {
+		return Config{}, err
+	}
+	if cfg.IPRateLimitRPS, err = floatFromEnv(getenv, "IP_RATE_LIMIT_RPS", cfg.IPRateLimitRPS); err != nil {
+		return Config{}, err
+	}
+	if cfg.IPRateLimitBurst, err = intFromEnv(getenv, "IP_RATE_LIMIT_BURST", cfg.IPRateLimitBurst); err != nil {
+		return Config{}, err
+	}
+	resetSeconds, err := intFromEnv(getenv, "COTACAO_RESET_SECONDS", int(cfg.ResetTime/time.Second))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ResetTime = time.Duration(resetSeconds) * time.Second
+	if cfg.ShutdownTimeout, err = durationFromEnv(getenv, "SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.RequestTimeout, err = durationFromEnv(getenv, "REQUEST_TIMEOUT", cfg.RequestTimeout); err != nil {
+		return Config{}, err
+	}
+	if cfg.WriteQueueSize, err = intFromEnv(getenv, "WRITE_QUEUE_SIZE", cfg.WriteQueueSize); err != nil {
+		return Config{}, err
+	}
+	if cfg.WriteFlush, err = durationFromEnv(getenv, "WRITE_FLUSH_INTERVAL", cfg.WriteFlush); err != nil {
+		return Config{}, err
+	}
+	maxBodySize, err := intFromEnv(getenv, "COTACAO_MAX_BODY_BYTES", int(cfg.MaxBodySize))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.MaxBodySize = int64(maxBodySize)
+	if cfg.GzipMinSize, err = intFromEnv(getenv, "GZIP_MIN_SIZE", cfg.GzipMinSize); err != nil {
+		return Config{}, err
+	}
+	if cfg.BidScale, err = intFromEnv(getenv, "BID_SCALE", cfg.BidScale); err != nil {
+		return Config{}, err
+	}
+	if cfg.CacheTTL, err = durationFromEnv(getenv, "CACHE_TTL", cfg.CacheTTL); err != nil {
+		return Config{}, err
+	}
+	if cfg.FetchConcurrency, err = intFromEnv(getenv, "FETCH_CONCURRENCY", cfg.FetchConcurrency); err != nil {
+		return Config{}, err
+	}
+	if cfg.StreamInterval, err = durationFromEnv(getenv, "STREAM_INTERVAL", cfg.StreamInterval); err != nil {
+		return Config{}, err
+	}
+	if cfg.RefreshInterval, err = durationFromEnv(getenv, "REFRESH_INTERVAL", cfg.RefreshInterval); err != nil {
+		return Config{}, err
+	}
+	if cfg.DBPool.MaxOpenConns, err = intFromEnv(getenv, "DB_MAX_OPEN_CONNS", cfg.DBPool.MaxOpenConns); err != nil {
+		return Config{}, err
+	}
+	if cfg.DBPool.MaxIdleConns, err = intFromEnv(getenv, "DB_MAX_IDLE_CONNS", cfg.DBPool.MaxIdleConns); err != nil {
+		return Config{}, err
+	}
+	if cfg.DBPool.ConnMaxLifetime, err = durationFromEnv(getenv, "DB_CONN_MAX_LIFETIME", cfg.DBPool.ConnMaxLifetime); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.URL == "":
+		return errors.New("COTACAO_URL must not be empty")
+	case c.Retry < 0:
+		return fmt.Errorf("COTACAO_RETRY must be >= 0, got %d", c.Retry)
+	case c.RetryMaxElapsed < 0:
+		return fmt.Errorf("COTACAO_RETRY_MAX_ELAPSED must be >= 0, got %s", c.RetryMaxElapsed)
+	case c.FailureThreshold < 1:
+		return fmt.Errorf("COTACAO_FAILURE_THRESHOLD must be >= 1, got %d", c.FailureThreshold)
+	case c.ResetTime < 0:
+		return fmt.Errorf("COTACAO_RESET_SECONDS must be >= 0, got %s", c.ResetTime)
+	case c.StrictDecode && c.BidPath != "":
+		return errors.New("COTACAO_STRICT_DECODE only applies to the default response shape, unset COTACAO_BID_PATH")
+	case c.Fallback == "":
+		return errors.New("COTACAO_FALLBACK must not be empty")
+	case c.MaxBodySize < 1:
+		return fmt.Errorf("COTACAO_MAX_BODY_BYTES must be >= 1, got %d", c.MaxBodySize)
+	case c.BidScale < 1 || c.BidScale > 12:
+		return fmt.Errorf("BID_SCALE must be between 1 and 12, got %d", c.BidScale)
+	case c.StreamInterval <= 0:
+		return fmt.Errorf("STREAM_INTERVAL must be positive, got %s", c.StreamInterval)
+	case c.AsyncWrites && c.WriteQueueSize < 1:
+		return fmt.Errorf("WRITE_QUEUE_SIZE must be >= 1, got %d", c.WriteQueueSize)
+	case c.AsyncWrites && c.WriteFlush <= 0:
+		return fmt.Errorf("WRITE_FLUSH_INTERVAL must be positive, got %s", c.WriteFlush)
+	case c.FetchConcurrency < 0:
+		return fmt.Errorf("FETCH_CONCURRENCY must be >= 0, got %d", c.FetchConcurrency)
+	case c.GzipMinSize < 0:
+		return fmt.Errorf("GZIP_MIN_SIZE must be >= 0, got %d", c.GzipMinSize)
+	case c.RequestTimeout < 0:
+		return fmt.Errorf("REQUEST_TIMEOUT must be >= 0, got %s", c.RequestTimeout)
+	case c.RefreshInterval < 0:
+		return fmt.Errorf("REFRESH_INTERVAL must be >= 0, got %s", c.RefreshInterval)
+	case c.Retention < 0:
+		return fmt.Errorf("RETENTION must be >= 0, got %s", c.Retention)
+	case c.Retention > 0 && c.PruneInterval <= 0:
+		return fmt.Errorf("PRUNE_INTERVAL must be positive, got %s", c.PruneInterval)
+	case c.RateLimitRPS < 0:
+		return fmt.Errorf("RATE_LIMIT_RPS must be >= 0, got %v", c.RateLimitRPS)
+	case c.RateLimitRPS > 0 && c.RateLimitBurst < 1:
+		return fmt.Errorf("RATE_LIMIT_BURST must be >= 1, got %d", c.RateLimitBurst)
+	case c.IPRateLimitRPS < 0:
+		return fmt.Errorf("IP_RATE_LIMIT_RPS must be >= 0, got %v", c.IPRateLimitRPS)
+	case c.IPRateLimitRPS > 0 && c.IPRateLimitBurst < 1:
+		return fmt.Errorf("IP_RATE_LIMIT_BURST must be >= 1, got %d", c.IPRateLimitBurst)
+	case c.ListenAddr == "":
+		return errors.New("LISTEN_ADDR must not be empty")
+	case (c.TLSCertFile == "") != (c.TLSKeyFile == ""):
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case c.LogFormat != "text" && c.LogFormat != "json":
+		return fmt.Errorf("LOG_FORMAT must be text or json, got %q", c.LogFormat)
+	case c.DBDriver != "sqlite3" && c.DBDriver != "postgres":
+		return fmt.Errorf("unsupported DB_DRIVER %q", c.DBDriver)
+	case c.DBPool.MaxOpenConns < 0:
+		return fmt.Errorf("DB_MAX_OPEN_CONNS must be >= 0, got %d", c.DBPool.MaxOpenConns)
+	case c.DBPool.MaxIdleConns < 0:
+		return fmt.Errorf("DB_MAX_IDLE_CONNS must be >= 0, got %d", c.DBPool.MaxIdleConns)
+	case c.DBPool.ConnMaxLifetime < 0:
+		return fmt.Errorf("DB_CONN_MAX_LIFETIME must be >= 0, got %s", c.DBPool.ConnMaxLifetime)
+	}
+	if err := validateListenAddr(c.ListenAddr); err != nil {
+		return fmt.Errorf("LISTEN_ADDR: %w", err)
+	}
+	return nil
+}
+
+// validateListenAddr accepts host:port where host is empty (all interfaces),
+// an IP address or a host name, and port is a number from 0 to 65535.
+func validateListenAddr(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("expected host:port such as :8080 or 127.0.0.1:8080, got %q", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("port must be a number from 0 to 65535, got %q", port)
+	}
+	if strings.ContainsAny(host, " /") {
+		return fmt.Errorf("invalid host %q", host)
+	}
+	return nil
+}
+
+// parseHeaders reads items like "User-Agent: cotacao/1.0" into a header set.
+func parseHeaders(items []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("COTACAO_HEADERS: expected Name: value, got %q", item)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// parseCategoryThresholds reads items like "decode=1" into per-category
+// circuit breaker thresholds.
+func parseCategoryThresholds(items []string) (map[cotacao.FailureCategory]int, error) {
+	categories := map[string]cotacao.FailureCategory{
+		"connection": cotacao.FailureConnection,
+		"timeout":    cotacao.FailureTimeout,
+		"status":     cotacao.FailureStatus,
+		"decode":     cotacao.FailureDecode,
+	}
+
+	thresholds := make(map[cotacao.FailureCategory]int)
+	for _, item := range items {
+		name, value, _ := strings.Cut(item, "=")
+		category, ok := categories[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("COTACAO_CATEGORY_THRESHOLDS: unknown category %q, expected connection, timeout, status or decode", name)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("COTACAO_CATEGORY_THRESHOLDS: threshold for %s must be an integer >= 1, got %q", name, value)
+		}
+		thresholds[category] = n
+	}
+	return thresholds, nil
+}
+
+func parseFallbackPolicy(v string) (cotacao.FallbackPolicy, error) {
+	switch v {
+	case "static":
+		return cotacao.FallbackStatic, nil
+	case "last_known":
+		return cotacao.FallbackLastKnown, nil
+	case "error":
+		return cotacao.FallbackError, nil
+	}
+	return 0, fmt.Errorf("COTACAO_FALLBACK_POLICY must be static, last_known or error, got %q", v)
+}
+
+// listFromEnv splits a comma-separated variable, dropping empty entries.
+func listFromEnv(getenv func(string) string, name string) []string {
+	var list []string
+	for _, item := range strings.Split(getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func stringFromEnv(getenv func(string) string, name string, dst *string) {
+	if v := getenv(name); v != "" {
+		*dst = v
+	}
+}
+
+func intFromEnv(getenv func(string) string, name string, fallback int) (int, error) {
+	v := getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	return n, nil
+}
+
+func floatFromEnv(getenv func(string) string, name string, fallback float64) (float64, error) {
+	v := getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	return f, nil
+}
+
+func durationFromEnv(getenv func(string) string, name string, fallback time.Duration) (time.Duration, error) {
+	v := getenv(name)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	return d, nil
+}
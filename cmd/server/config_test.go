@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a getenv reading from vars.
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := LoadConfig(env(nil))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	want := DefaultConfig()
+	if cfg.URL != want.URL || cfg.Retry != want.Retry || cfg.FailureThreshold != want.FailureThreshold ||
+		cfg.ResetTime != want.ResetTime || cfg.Fallback != want.Fallback || cfg.ListenAddr != want.ListenAddr {
+		t.Errorf("LoadConfig with no variables = %+v, want the defaults %+v", cfg, want)
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	cfg, err := LoadConfig(env(map[string]string{
+		"COTACAO_URL":               "http://upstream.test/json/last/",
+		"COTACAO_RETRY":             "5",
+		"COTACAO_FAILURE_THRESHOLD": "4",
+		"COTACAO_RESET_SECONDS":     "30",
+		"COTACAO_FALLBACK":          "5.00",
+		"LISTEN_ADDR":               "127.0.0.1:9090",
+	}))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	if cfg.URL != "http://upstream.test/json/last/" || cfg.Retry != 5 || cfg.FailureThreshold != 4 ||
+		cfg.ResetTime != 30*time.Second || cfg.Fallback != "5.00" || cfg.ListenAddr != "127.0.0.1:9090" {
+		t.Errorf("LoadConfig = %+v, want every override applied", cfg)
+	}
+}
+
+func TestLoadConfigHeaders(t *testing.T) {
+	cfg, err := LoadConfig(env(map[string]string{
+		"COTACAO_HEADERS": "User-Agent: cotacao/1.0",
+		"COTACAO_API_KEY": "secret",
+	}))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if ua, key := cfg.Headers.Get("User-Agent"), cfg.Headers.Get("X-API-Key"); ua != "cotacao/1.0" || key != "secret" {
+		t.Errorf("User-Agent = %q, X-API-Key = %q; want cotacao/1.0 and secret", ua, key)
+	}
+
+	cfg, err = LoadConfig(env(map[string]string{
+		"COTACAO_API_KEY":        "secret",
+		"COTACAO_API_KEY_HEADER": "Authorization",
+	}))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if key := cfg.Headers.Get("Authorization"); key != "secret" {
+		t.Errorf("Authorization = %q, want the key under COTACAO_API_KEY_HEADER", key)
+	}
+
+	if _, err := LoadConfig(env(map[string]string{"COTACAO_HEADERS": "no colon"})); err == nil || !strings.Contains(err.Error(), "COTACAO_HEADERS") {
+		t.Errorf("LoadConfig with a malformed header = %v, want an error naming COTACAO_HEADERS", err)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		key, value string
+	}{
+		{"COTACAO_RETRY", "three"},
+		{"COTACAO_RETRY", "-1"},
+		{"COTACAO_FAILURE_THRESHOLD", "1.5"},
+		{"COTACAO_FAILURE_THRESHOLD", "0"},
+		{"COTACAO_RESET_SECONDS", "soon"},
+		{"LISTEN_ADDR", "not an address"},
+		{"TLS_CERT_FILE", "cert.pem"},
+		{"TLS_KEY_FILE", "key.pem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			_, err := LoadConfig(env(map[string]string{tt.key: tt.value}))
+			if err == nil {
+				t.Fatalf("LoadConfig accepted %s=%q", tt.key, tt.value)
+			}
+			if !strings.Contains(err.Error(), tt.key) {
+				t.Errorf("error %q does not name %s", err, tt.key)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// runServer serves on ln, over HTTPS when certFile and keyFile are both set
+// and plain HTTP otherwise, shutting down gracefully once ctx is done.
+func runServer(ctx context.Context, srv *http.Server, ln net.Listener, shutdownTimeout time.Duration, certFile, keyFile string) error {
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if certFile != "" && keyFile != "" {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+func main() {
+	cfg, err := LoadConfig(os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	slog.SetDefault(cotacao.NewLogger(os.Stderr, cfg.LogFormat, cfg.LogLevel))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var db *sql.DB
+	var repository cotacao.CotacaoRepository
+	// DB_DRIVER picks the SQL dialect; DB_DRIVER_NAME, when set, the
+	// database/sql driver speaking it.
+	driverName := cfg.DBDriverName
+	if driverName == "" {
+		driverName = cfg.DBDriver
+	}
+	switch cfg.DBDriver {
+	case "sqlite3":
+		db, err = cotacao.OpenSQLiteDriver(driverName, cfg.DBPath)
+		repository = cotacao.NewSQLiteCotacaoRepository(db)
+	case "postgres":
+		db, err = cotacao.OpenPostgresDriver(driverName, cfg.DatabaseURL, cfg.DBPool)
+		repository = cotacao.NewPostgresCotacaoRepository(db)
+	}
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	registry := prometheus.NewRegistry()
+	metrics := cotacao.NewFetcherMetrics(cfg.MetricsPrefix, registry)
+	repository = cotacao.NewMeasuredCotacaoRepository(repository, cotacao.NewRepositoryMetrics(cfg.MetricsPrefix, registry))
+
+	backoff := cotacao.Backoff{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Multiplier: 2}
+	options := []cotacao.Option{
+		cotacao.WithRetry(cfg.Retry),
+		cotacao.WithRetryMaxElapsed(cfg.RetryMaxElapsed),
+		cotacao.WithFailureThreshold(cfg.FailureThreshold),
+		cotacao.WithResetTime(cfg.ResetTime),
+		cotacao.WithFallback(cfg.Fallback),
+		cotacao.WithFallbackPolicy(cfg.FallbackPolicy),
+		cotacao.WithBackoff(backoff),
+		cotacao.WithMaxBodySize(cfg.MaxBodySize),
+		cotacao.WithHeaders(cfg.Headers),
+		cotacao.WithMetrics(metrics),
+	}
+	for category, n := range cfg.CategoryThresholds {
+		options = append(options, cotacao.WithCategoryThreshold(category, n))
+	}
+	var extractor cotacao.Extractor
+	switch {
+	case cfg.BidPath != "":
+		extractor = cotacao.JSONPathExtractor(cfg.BidPath)
+	case cfg.StrictDecode:
+		extractor = cotacao.StrictAwesomeAPIExtractor
+	}
+	if extractor != nil {
+		options = append(options, cotacao.WithExtractor(extractor))
+	}
+	if len(cfg.FallbackFiles) > 0 {
+		providers := make([]cotacao.CotacaoFetcher, len(cfg.FallbackFiles))
+		for i, path := range cfg.FallbackFiles {
+			providers[i] = cotacao.NewFileCotacaoFetcher(path, extractor)
+		}
+		options = append(options, cotacao.WithFallbackProviders(providers...))
+	}
+	var fetcher cotacao.CotacaoFetcher
+	if cfg.File != "" {
+		slog.Info("Reading cotacoes from file", "path", cfg.File)
+		fetcher = cotacao.NewFileCotacaoFetcher(cfg.File, extractor)
+	} else if fetcher, err = cotacao.NewApiCotacaoFetcher(cfg.URL, options...); err != nil {
+		log.Fatalf("Error creating fetcher: %v", err)
+	}
+	if cfg.FetchConcurrency > 0 {
+		fetcher = cotacao.NewLimitedCotacaoFetcher(fetcher, int64(cfg.FetchConcurrency), cfg.FetchWait)
+	}
+	if cfg.CacheTTL > 0 {
+		fetcher = cotacao.NewCachingCotacaoFetcher(fetcher, cfg.CacheTTL)
+	}
+	var refresher *cotacao.Refresher
+	if cfg.RefreshInterval > 0 {
+		refresher = cotacao.NewRefresher(fetcher, cotacao.DefaultPair, cfg.RefreshInterval, cotacao.DefaultServerConfig().FetchTimeout)
+		fetcher = refresher
+	}
+	serverConfig := cotacao.DefaultServerConfig()
+	serverConfig.AllowInjection = cfg.AllowInjection
+	serverConfig.DryRun = cfg.DryRun
+	serverConfig.Dedupe = cfg.Dedupe
+	serverConfig.TolerateSaveErrors = cfg.TolerateSaveErrors
+	serverConfig.LegacyResponse = cfg.LegacyResponse
+	if cfg.AsyncWrites {
+		queue := cotacao.NewWriteQueue(repository, cfg.WriteQueueSize, cfg.WriteFlush)
+		registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: cfg.MetricsPrefix,
+			Name:      "write_queue_dropped_total",
+			Help:      "Number of queued quotes dropped because the database rejected them.",
+		}, func() float64 { return float64(queue.Dropped()) }))
+		serverConfig.WriteQueue = queue
+		go queue.Run()
+	}
+	serverConfig.BidScale = int32(cfg.BidScale)
+	server := cotacao.NewServer(fetcher, repository, serverConfig)
+	hub := cotacao.NewQuoteHub(fetcher, cotacao.DefaultPair, cfg.StreamInterval, cotacao.DefaultServerConfig().FetchTimeout)
+
+	// The per-IP limiter runs first so one client exhausting its bucket does
+	// not drain the global one.
+	var ipLimiter *cotacao.IPRateLimiter
+	var rateLimits []cotacao.Middleware
+	if cfg.IPRateLimitRPS > 0 {
+		ipLimiter, err = cotacao.NewIPRateLimiter(cfg.IPRateLimitRPS, cfg.IPRateLimitBurst, cfg.TrustedProxies)
+		if err != nil {
+			log.Fatalf("Error creating rate limiter: %v", err)
+		}
+		rateLimits = append(rateLimits, ipLimiter.Middleware)
+	}
+	if cfg.RateLimitRPS > 0 {
+		rateLimits = append(rateLimits, cotacao.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst).Middleware)
+	}
+	compress := cotacao.NewCompressor(cfg.GzipMinSize).Middleware
+
+	mux := http.NewServeMux()
+	mux.Handle("/cotacao", cotacao.Chain(rateLimits...)(http.HandlerFunc(server.CotacaoHandler)))
+	mux.Handle("/cotacao/history", compress(http.HandlerFunc(server.HistoryHandler)))
+	mux.Handle("/cotacao/latest", compress(http.HandlerFunc(server.LatestHandler)))
+	mux.Handle("/cotacao/range", compress(http.HandlerFunc(server.RangeHandler)))
+	mux.HandleFunc("/cotacao/average", server.AverageHandler)
+	mux.HandleFunc("/cotacao/stats", server.QuoteStatsHandler)
+	mux.Handle("/cotacao/export.csv", compress(http.HandlerFunc(server.ExportHandler)))
+	mux.HandleFunc("/cotacao/stream", hub.StreamHandler)
+	mux.HandleFunc("/health", server.HealthHandler)
+	mux.HandleFunc("/ready", server.ReadyHandler)
+	mux.HandleFunc("/stats", server.StatsHandler)
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if refresher != nil {
+		if err := refresher.Refresh(ctx); err != nil {
+			slog.Warn("Initial cotacao refresh failed", "error", err)
+		}
+		go refresher.Run(ctx)
+	}
+	go hub.Run(ctx)
+	if ipLimiter != nil {
+		go ipLimiter.RunCleanup(ctx, time.Minute, 10*time.Minute)
+	}
+	if cfg.Retention > 0 {
+		go cotacao.RunPruner(ctx, repository, cfg.PruneInterval, cfg.Retention)
+	}
+
+	var cors cotacao.Middleware
+	if len(cfg.CORSOrigins) > 0 {
+		cors = cotacao.NewCORS(cfg.CORSOrigins).Middleware
+	}
+	// The stream stays open indefinitely and the export writes rows as it
+	// reads them, so neither fits a buffered, time-boxed response.
+	var timeout cotacao.Middleware
+	if cfg.RequestTimeout > 0 {
+		timeout = cotacao.NewTimeout(cfg.RequestTimeout, "/cotacao/stream", "/cotacao/export.csv").Middleware
+	}
+	handler := cotacao.Chain(
+		cotacao.WithRequestID,
+		cotacao.WithCorrelationID,
+		cotacao.LogRequests,
+		cors,
+		timeout,
+	)(mux)
+
+	// Listening here rather than in runServer reports the actual address,
+	// which differs from LISTEN_ADDR when it asks for port 0.
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		log.Fatalf("Error listening on %s: %v", cfg.ListenAddr, err)
+	}
+	slog.Info("Listening", "addr", ln.Addr().String())
+
+	srv := &http.Server{Addr: cfg.ListenAddr, Handler: handler}
+	if err := runServer(ctx, srv, ln, cfg.ShutdownTimeout, cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+		slog.Error("Server error", "error", err)
+	}
+
+	if serverConfig.WriteQueue != nil {
+		drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := serverConfig.WriteQueue.Close(drainCtx); err != nil {
+			slog.Error("Error draining write queue", "error", err)
+		}
+	}
+}
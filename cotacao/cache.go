@@ -0,0 +1,95 @@
+package cotacao
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type freshKey struct{}
+
+// WithFresh marks ctx so CachingCotacaoFetcher and Refresher skip the value
+// they hold and fetch upstream, storing the new result as usual.
+func WithFresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshKey{}, true)
+}
+
+func isFresh(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshKey{}).(bool)
+	return fresh
+}
+
+type cacheEntry struct {
+	result    FetchResult
+	fetchedAt time.Time
+}
+
+type CachingCotacaoFetcher struct {
+	fetcher CotacaoFetcher
+	ttl     time.Duration
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+	group   singleflight.Group
+}
+
+func NewCachingCotacaoFetcher(fetcher CotacaoFetcher, ttl time.Duration) CotacaoFetcher {
+	return &CachingCotacaoFetcher{
+		fetcher: fetcher,
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *CachingCotacaoFetcher) Fetch(ctx context.Context, pair string) (FetchResult, error) {
+	c.mu.RLock()
+	entry, ok := c.entries[pair]
+	c.mu.RUnlock()
+	if ok && !isFresh(ctx) && time.Since(entry.fetchedAt) < c.ttl {
+		result := entry.result
+		result.Source, result.Latency = SourceCache, 0
+		return result, nil
+	}
+
+	// Callers sharing a fetch each wait on their own ctx, and the fetch runs
+	// detached from the first caller's cancellation.
+	ch := c.group.DoChan(pair, func() (interface{}, error) {
+		fetchCtx, cancel := detach(ctx)
+		defer cancel()
+		result, err := c.fetcher.Fetch(fetchCtx, pair)
+		// Fallback values are not cached so the next request retries upstream.
+		if err != nil || result.Source == SourceFallback {
+			return result, err
+		}
+
+		c.mu.Lock()
+		c.entries[pair] = cacheEntry{result: result, fetchedAt: time.Now()}
+		c.mu.Unlock()
+		return result, nil
+	})
+	select {
+	case res := <-ch:
+		return res.Val.(FetchResult), res.Err
+	case <-ctx.Done():
+		return FetchResult{}, ctx.Err()
+	}
+}
+
+// detach keeps the values of ctx and the time left before its deadline,
+// DefaultFetchTimeout without one, but not its cancellation.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := DefaultFetchTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+func (c *CachingCotacaoFetcher) Probe(ctx context.Context) error {
+	return probeOf(ctx, c.fetcher)
+}
+
+func (c *CachingCotacaoFetcher) State() CircuitState {
+	return stateOf(c.fetcher)
+}
@@ -0,0 +1,112 @@
+package cotacao_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+// gateFetcher counts its calls and holds each one until release is closed.
+type gateFetcher struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func newGateFetcher() *gateFetcher {
+	return &gateFetcher{started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (f *gateFetcher) Fetch(ctx context.Context, pair string) (cotacao.FetchResult, error) {
+	f.calls.Add(1)
+	f.started <- struct{}{}
+	select {
+	case <-f.release:
+		return cotacao.FetchResult{Bid: "5.4321", Source: cotacao.SourceLive}, nil
+	case <-ctx.Done():
+		return cotacao.FetchResult{}, ctx.Err()
+	}
+}
+
+func TestCachingFetcherCollapsesConcurrentMisses(t *testing.T) {
+	upstream := newGateFetcher()
+	cache := cotacao.NewCachingCotacaoFetcher(upstream, time.Minute)
+
+	const callers = 50
+	var ready, done sync.WaitGroup
+	ready.Add(callers)
+	done.Add(callers)
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer done.Done()
+			ready.Done()
+			result, err := cache.Fetch(context.Background(), cotacao.DefaultPair)
+			if err == nil && result.Bid != "5.4321" {
+				err = errors.New("got bid " + result.Bid)
+			}
+			errs <- err
+		}()
+	}
+	ready.Wait()
+	<-upstream.started
+	// Let the other callers reach the in-flight fetch before it completes.
+	time.Sleep(20 * time.Millisecond)
+	close(upstream.release)
+	done.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Fetch: %v", err)
+		}
+	}
+	if n := upstream.calls.Load(); n != 1 {
+		t.Errorf("upstream called %d times for %d concurrent misses, want 1", n, callers)
+	}
+	if result, _ := cache.Fetch(context.Background(), cotacao.DefaultPair); result.Source != cotacao.SourceCache {
+		t.Errorf("source after the miss = %q, want %q", result.Source, cotacao.SourceCache)
+	}
+}
+
+func TestCachingFetcherSurvivesFirstCallerCancelling(t *testing.T) {
+	upstream := newGateFetcher()
+	cache := cotacao.NewCachingCotacaoFetcher(upstream, time.Minute)
+
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Fetch(first, cotacao.DefaultPair)
+		firstErr <- err
+	}()
+	<-upstream.started
+
+	type result struct {
+		cotacao.FetchResult
+		err error
+	}
+	second := make(chan result, 1)
+	go func() {
+		r, err := cache.Fetch(context.Background(), cotacao.DefaultPair)
+		second <- result{r, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller got %v, want %v", err, context.Canceled)
+	}
+	close(upstream.release)
+
+	if r := <-second; r.err != nil || r.Bid != "5.4321" {
+		t.Errorf("second caller got %+v, %v; want bid 5.4321 despite the first cancelling", r.FetchResult, r.err)
+	}
+	if n := upstream.calls.Load(); n != 1 {
+		t.Errorf("upstream called %d times, want the second caller to share the first fetch", n)
+	}
+}
@@ -0,0 +1,81 @@
+// Package cotacaotest wires the real cotacao components against a fake
+// upstream and an in-memory SQLite database for integration tests.
+package cotacaotest
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+// AwesomeAPIPayload is a canned upstream response for USD-BRL.
+const AwesomeAPIPayload = `{"USDBRL":{"code":"USD","codein":"BRL","bid":"5.4321"}}`
+
+var dbCounter atomic.Int64
+
+// Harness is an ApiCotacaoFetcher pointed at Upstream, a SQLite repository
+// on DB and a Server using both. Handler routes the server's endpoints.
+type Harness struct {
+	Upstream   *httptest.Server
+	DB         *sql.DB
+	Repository cotacao.CotacaoRepository
+	Fetcher    cotacao.CotacaoFetcher
+	Server     *cotacao.Server
+	Handler    http.Handler
+}
+
+// NewHarness starts an upstream answering every request with payload and
+// builds the stack around it. Call Close when done.
+func NewHarness(payload string, opts ...cotacao.Option) (*Harness, error) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, payload)
+	}))
+
+	// A distinct name per harness keeps shared-cache in-memory databases apart.
+	db, err := cotacao.OpenSQLite(fmt.Sprintf("file:cotacaotest-%d?mode=memory&cache=shared", dbCounter.Add(1)))
+	if err != nil {
+		upstream.Close()
+		return nil, err
+	}
+
+	fetcher, err := cotacao.NewApiCotacaoFetcher(upstream.URL+"/json/last/", opts...)
+	if err != nil {
+		db.Close()
+		upstream.Close()
+		return nil, err
+	}
+
+	repository := cotacao.NewSQLiteCotacaoRepository(db)
+	server := cotacao.NewServer(fetcher, repository, cotacao.DefaultServerConfig())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cotacao", server.CotacaoHandler)
+	mux.HandleFunc("/cotacao/history", server.HistoryHandler)
+	mux.HandleFunc("/cotacao/latest", server.LatestHandler)
+	mux.HandleFunc("/cotacao/range", server.RangeHandler)
+	mux.HandleFunc("/cotacao/average", server.AverageHandler)
+	mux.HandleFunc("/cotacao/stats", server.QuoteStatsHandler)
+	mux.HandleFunc("/cotacao/export.csv", server.ExportHandler)
+	mux.HandleFunc("/health", server.HealthHandler)
+	mux.HandleFunc("/ready", server.ReadyHandler)
+	mux.HandleFunc("/stats", server.StatsHandler)
+
+	return &Harness{
+		Upstream:   upstream,
+		DB:         db,
+		Repository: repository,
+		Fetcher:    fetcher,
+		Server:     server,
+		Handler:    mux,
+	}, nil
+}
+
+func (h *Harness) Close() {
+	h.DB.Close()
+	h.Upstream.Close()
+}
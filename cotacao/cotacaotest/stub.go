@@ -0,0 +1,316 @@
+package cotacaotest
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+var (
+	_ cotacao.CotacaoFetcher    = (*StubCotacaoFetcher)(nil)
+	_ cotacao.CotacaoRepository = (*StubCotacaoRepository)(nil)
+	_ cotacao.Clock             = (*FakeClock)(nil)
+)
+
+// StubCotacaoFetcher is a cotacao.CotacaoFetcher for tests returning Bid,
+// Source (cotacao.SourceLive when empty) and Err and recording the pairs it
+// was asked for.
+type StubCotacaoFetcher struct {
+	Bid    string
+	Source string
+	Err    error
+
+	mu    sync.Mutex
+	pairs []string
+}
+
+func (f *StubCotacaoFetcher) Fetch(ctx context.Context, pair string) (cotacao.FetchResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pairs = append(f.pairs, pair)
+	source := f.Source
+	if source == "" {
+		source = cotacao.SourceLive
+	}
+	return cotacao.FetchResult{Bid: f.Bid, Source: source}, f.Err
+}
+
+func (f *StubCotacaoFetcher) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.pairs)
+}
+
+func (f *StubCotacaoFetcher) Pairs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.pairs...)
+}
+
+// StubCotacaoRepository is an in-memory cotacao.CotacaoRepository for tests.
+// When Err is set every method fails with it; calls are counted per method
+// name.
+type StubCotacaoRepository struct {
+	Err error
+
+	mu     sync.Mutex
+	rows   []cotacao.StoredCotacao
+	nextID int64
+	calls  map[string]int
+}
+
+func (r *StubCotacaoRepository) record(method string) error {
+	if r.calls == nil {
+		r.calls = make(map[string]int)
+	}
+	r.calls[method]++
+	return r.Err
+}
+
+func (r *StubCotacaoRepository) Calls(method string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls[method]
+}
+
+func (r *StubCotacaoRepository) Rows() []cotacao.StoredCotacao {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]cotacao.StoredCotacao(nil), r.rows...)
+}
+
+func (r *StubCotacaoRepository) insert(pair, bid, source string, fetchedAt time.Time, latencyMs int64) {
+	r.nextID++
+	r.rows = append(r.rows, cotacao.StoredCotacao{
+		ID:        r.nextID,
+		Pair:      pair,
+		Bid:       bid,
+		Source:    source,
+		Timestamp: timestamp(fetchedAt),
+		LatencyMs: latencyMs,
+	})
+}
+
+// timestamp mirrors the repositories: UTC, with the zero value meaning now.
+func timestamp(fetchedAt time.Time) time.Time {
+	if fetchedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return fetchedAt.UTC()
+}
+
+// newestFirst returns up to limit rows with an id below afterID (any id when
+// afterID <= 0), newest first.
+func (r *StubCotacaoRepository) newestFirst(afterID int64, limit int) []cotacao.StoredCotacao {
+	result := []cotacao.StoredCotacao{}
+	for i := len(r.rows) - 1; i >= 0 && len(result) < limit; i-- {
+		if afterID <= 0 || r.rows[i].ID < afterID {
+			result = append(result, r.rows[i])
+		}
+	}
+	return result
+}
+
+func (r *StubCotacaoRepository) Save(ctx context.Context, pair, bid, source string, fetchedAt time.Time, latency time.Duration) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("Save"); err != nil {
+		return 0, err
+	}
+	r.insert(pair, bid, source, fetchedAt, latency.Milliseconds())
+	return r.nextID, nil
+}
+
+func (r *StubCotacaoRepository) List(ctx context.Context, limit int) ([]cotacao.StoredCotacao, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("List"); err != nil {
+		return nil, err
+	}
+	return r.newestFirst(0, limit), nil
+}
+
+func (r *StubCotacaoRepository) ListAfter(ctx context.Context, afterID int64, limit int) ([]cotacao.StoredCotacao, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("ListAfter"); err != nil {
+		return nil, err
+	}
+	return r.newestFirst(afterID, limit), nil
+}
+
+func (r *StubCotacaoRepository) Latest(ctx context.Context) (cotacao.StoredCotacao, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("Latest"); err != nil {
+		return cotacao.StoredCotacao{}, err
+	}
+	if len(r.rows) == 0 {
+		return cotacao.StoredCotacao{}, sql.ErrNoRows
+	}
+	return r.rows[len(r.rows)-1], nil
+}
+
+func (r *StubCotacaoRepository) LatestByPair(ctx context.Context, pair string) (cotacao.StoredCotacao, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("LatestByPair"); err != nil {
+		return cotacao.StoredCotacao{}, err
+	}
+	for i := len(r.rows) - 1; i >= 0; i-- {
+		if r.rows[i].Pair == pair {
+			return r.rows[i], nil
+		}
+	}
+	return cotacao.StoredCotacao{}, sql.ErrNoRows
+}
+
+func (r *StubCotacaoRepository) Range(ctx context.Context, from, to time.Time, limit int) ([]cotacao.StoredCotacao, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("Range"); err != nil {
+		return nil, err
+	}
+	result := []cotacao.StoredCotacao{}
+	for _, row := range r.rows {
+		if len(result) == limit {
+			break
+		}
+		if !row.Timestamp.Before(from) && !row.Timestamp.After(to) {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+func (r *StubCotacaoRepository) SaveBatch(ctx context.Context, items []cotacao.StoredCotacao) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("SaveBatch"); err != nil {
+		return err
+	}
+	for _, item := range items {
+		pair, source := item.Pair, item.Source
+		if pair == "" {
+			pair = cotacao.DefaultPair
+		}
+		if source == "" {
+			source = cotacao.SourceLive
+		}
+		r.insert(pair, item.Bid, source, item.Timestamp, item.LatencyMs)
+	}
+	return nil
+}
+
+func (r *StubCotacaoRepository) Prune(ctx context.Context, olderThan time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("Prune"); err != nil {
+		return 0, err
+	}
+	kept := r.rows[:0]
+	for _, row := range r.rows {
+		if !row.Timestamp.Before(olderThan) {
+			kept = append(kept, row)
+		}
+	}
+	removed := int64(len(r.rows) - len(kept))
+	r.rows = kept
+	return removed, nil
+}
+
+func (r *StubCotacaoRepository) Average(ctx context.Context, n int) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("Average"); err != nil {
+		return 0, err
+	}
+	recent := r.newestFirst(0, n)
+	if len(recent) == 0 {
+		return 0, sql.ErrNoRows
+	}
+	var sum float64
+	for _, row := range recent {
+		value, _ := strconv.ParseFloat(row.Bid, 64)
+		sum += value
+	}
+	return sum / float64(len(recent)), nil
+}
+
+func (r *StubCotacaoRepository) Page(ctx context.Context, afterID int64, limit int) ([]cotacao.StoredCotacao, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("Page"); err != nil {
+		return nil, err
+	}
+	page := []cotacao.StoredCotacao{}
+	for _, row := range r.rows {
+		if len(page) == limit {
+			break
+		}
+		if row.ID > afterID {
+			page = append(page, row)
+		}
+	}
+	return page, nil
+}
+
+func (r *StubCotacaoRepository) Stats(ctx context.Context, since time.Time) (cotacao.Stats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.record("Stats"); err != nil {
+		return cotacao.Stats{}, err
+	}
+	var stats cotacao.Stats
+	var sum float64
+	for _, row := range r.rows {
+		if row.Timestamp.Before(since) {
+			continue
+		}
+		value, _ := strconv.ParseFloat(row.Bid, 64)
+		if stats.Count == 0 || value < stats.Min {
+			stats.Min = value
+		}
+		if stats.Count == 0 || value > stats.Max {
+			stats.Max = value
+		}
+		stats.Count++
+		sum += value
+	}
+	if stats.Count > 0 {
+		stats.Avg = sum / float64(stats.Count)
+	}
+	return stats, nil
+}
+
+func (r *StubCotacaoRepository) Ping(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.record("Ping")
+}
+
+// FakeClock is a cotacao.Clock for tests that only moves when advanced.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
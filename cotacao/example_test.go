@@ -0,0 +1,25 @@
+package cotacao_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+// Example serves /cotacao from an importing package, with stubs standing in
+// for the upstream and the database.
+func Example() {
+	config := cotacao.DefaultServerConfig()
+	config.LegacyResponse = true
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, &cotacaotest.StubCotacaoRepository{}, config)
+
+	rec := httptest.NewRecorder()
+	server.CotacaoHandler(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+
+	fmt.Print(rec.Code, " ", rec.Body)
+	// Output:
+	// 200 {"bid":"5.4300","source":"live","persisted":true}
+}
@@ -0,0 +1,157 @@
+package cotacao
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Extractor pulls the quote for pair out of an upstream response body. Only
+// the bid is required; providers without the other fields leave them empty.
+type Extractor func(body []byte, pair string) (Cotacao, error)
+
+// AwesomeAPIExtractor reads the awesomeapi shape {"USDBRL":{"bid":"..."}}.
+// The values may also be JSON numbers, as some compatible providers send.
+func AwesomeAPIExtractor(body []byte, pair string) (Cotacao, error) {
+	key := strings.ReplaceAll(pair, "-", "")
+
+	var result map[string]looseQuote
+	if err := json.Unmarshal(body, &result); err != nil {
+		return Cotacao{}, err
+	}
+	quote, ok := result[key]
+	if !ok || quote.Bid == "" {
+		return Cotacao{}, fmt.Errorf("%w: %s", ErrMissingPair, key)
+	}
+	return quote.cotacao(), nil
+}
+
+// numberOrString decodes a JSON string or number into its text, so 5.43 and
+// "5.43" both read as "5.43".
+type numberOrString string
+
+func (v *numberOrString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*v = numberOrString(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*v = numberOrString(n)
+	return nil
+}
+
+type looseQuote struct {
+	Bid       numberOrString `json:"bid"`
+	Ask       numberOrString `json:"ask"`
+	High      numberOrString `json:"high"`
+	Low       numberOrString `json:"low"`
+	VarBid    numberOrString `json:"varBid"`
+	PctChange numberOrString `json:"pctChange"`
+}
+
+func (q looseQuote) cotacao() Cotacao {
+	return Cotacao{
+		Bid:       string(q.Bid),
+		Ask:       string(q.Ask),
+		High:      string(q.High),
+		Low:       string(q.Low),
+		VarBid:    string(q.VarBid),
+		PctChange: string(q.PctChange),
+	}
+}
+
+// awesomeAPIQuote lists every field awesomeapi documents for a quote, so
+// StrictAwesomeAPIExtractor can reject the ones it does not.
+type awesomeAPIQuote struct {
+	Cotacao
+	Code       string `json:"code"`
+	Codein     string `json:"codein"`
+	Name       string `json:"name"`
+	Timestamp  string `json:"timestamp"`
+	CreateDate string `json:"create_date"`
+}
+
+// StrictAwesomeAPIExtractor reads the same shape as AwesomeAPIExtractor but
+// fails on fields awesomeapi does not document, on numbers where awesomeapi
+// sends strings and on data after the JSON value, surfacing contract changes
+// instead of ignoring them.
+func StrictAwesomeAPIExtractor(body []byte, pair string) (Cotacao, error) {
+	key := strings.ReplaceAll(pair, "-", "")
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	var result map[string]awesomeAPIQuote
+	if err := decoder.Decode(&result); err != nil {
+		return Cotacao{}, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return Cotacao{}, errors.New("unexpected data after the JSON value")
+	}
+	quote, ok := result[key]
+	if !ok || quote.Bid == "" {
+		return Cotacao{}, fmt.Errorf("%w: %s", ErrMissingPair, key)
+	}
+	return quote.Cotacao, nil
+}
+
+// JSONPathExtractor reads the bid at a dotted path such as "rates.BRL" or
+// "data.0.price", where numeric segments index arrays. The placeholders
+// {base}, {quote} and {pair} are replaced with the parts of the pair, e.g.
+// "USD", "BRL" and "USDBRL". The value may be a JSON string or number.
+func JSONPathExtractor(path string) Extractor {
+	return func(body []byte, pair string) (Cotacao, error) {
+		bid, err := extractPath(body, pair, path)
+		return Cotacao{Bid: bid}, err
+	}
+}
+
+func extractPath(body []byte, pair, path string) (string, error) {
+	base, quote, _ := strings.Cut(pair, "-")
+	resolved := strings.NewReplacer("{base}", base, "{quote}", quote, "{pair}", base+quote).Replace(path)
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var node any
+	if err := decoder.Decode(&node); err != nil {
+		return "", err
+	}
+
+	for _, segment := range strings.Split(resolved, ".") {
+		switch v := node.(type) {
+		case map[string]any:
+			node = v[segment]
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("%w: %s", ErrMissingPair, resolved)
+			}
+			node = v[i]
+		default:
+			return "", fmt.Errorf("%w: %s", ErrMissingPair, resolved)
+		}
+	}
+
+	switch v := node.(type) {
+	case string:
+		if v != "" {
+			return v, nil
+		}
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrMissingPair, resolved)
+}
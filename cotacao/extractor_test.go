@@ -0,0 +1,130 @@
+package cotacao_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+func TestFetchWithJSONPathExtractor(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		payload string
+		bid     string
+	}{
+		{"rates object", "rates.BRL", `{"base":"USD","rates":{"BRL":5.4321,"EUR":0.92}}`, "5.4321"},
+		{"data array", "data.0.price", `{"data":[{"symbol":"USDBRL","price":"5.1234"},{"symbol":"EURBRL","price":"6.01"}]}`, "5.1234"},
+		{"pair placeholders", "quotes.{base}.{quote}", `{"quotes":{"USD":{"BRL":"5.5"}}}`, "5.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := upstream(t, servePayload(tt.payload))
+			fetcher := newFetcher(t, srv.URL+"/latest", cotacao.WithExtractor(cotacao.JSONPathExtractor(tt.path)))
+
+			result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+			if err != nil || result.Bid != tt.bid || result.Source != cotacao.SourceLive {
+				t.Errorf("Fetch = %+v, %v; want live bid %s", result, err, tt.bid)
+			}
+		})
+	}
+}
+
+func TestJSONPathExtractorMissingPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		payload string
+	}{
+		{"rates.BRL", `{"rates":{"EUR":0.92}}`},
+		{"data.1.price", `{"data":[{"price":"5.1234"}]}`},
+		{"data.x.price", `{"data":[{"price":"5.1234"}]}`},
+		{"rates.BRL.value", `{"rates":{"BRL":"5.43"}}`},
+		{"rates.BRL", `{"rates":{"BRL":""}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := cotacao.JSONPathExtractor(tt.path)([]byte(tt.payload), cotacao.DefaultPair)
+			if !errors.Is(err, cotacao.ErrMissingPair) {
+				t.Errorf("err = %v, want %v", err, cotacao.ErrMissingPair)
+			}
+		})
+	}
+}
+
+func TestAwesomeAPIExtractorStrictness(t *testing.T) {
+	tests := []struct {
+		name            string
+		payload         string
+		lenient, strict bool
+	}{
+		{"documented fields", `{"USDBRL":{"code":"USD","codein":"BRL","name":"Dólar","bid":"5.4321"}}`, true, true},
+		{"unknown field", `{"USDBRL":{"bid":"5.4321","provider":"new"}}`, true, false},
+		{"numeric bid", `{"USDBRL":{"bid":5.4321}}`, true, false},
+		{"trailing data", `{"USDBRL":{"bid":"5.4321"}} {"USDBRL":{"bid":"9"}}`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			extractors := []struct {
+				name    string
+				extract cotacao.Extractor
+				accepts bool
+			}{
+				{"lenient", cotacao.AwesomeAPIExtractor, tt.lenient},
+				{"strict", cotacao.StrictAwesomeAPIExtractor, tt.strict},
+			}
+			for _, e := range extractors {
+				got, err := e.extract([]byte(tt.payload), cotacao.DefaultPair)
+				switch {
+				case e.accepts && (err != nil || got.Bid != "5.4321"):
+					t.Errorf("%s = %+v, %v; want bid 5.4321", e.name, got, err)
+				case !e.accepts && err == nil:
+					t.Errorf("%s = %+v, want an error", e.name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBidAsStringOrNumber(t *testing.T) {
+	for _, payload := range []string{
+		`{"USDBRL":{"bid":"5.43"}}`,
+		`{"USDBRL":{"bid":5.43}}`,
+		`{"USDBRL":{"bid":5.430}}`,
+	} {
+		t.Run(payload, func(t *testing.T) {
+			h := newHarness(t, payload)
+
+			rec := serve(t, h.Handler.ServeHTTP, http.MethodGet, "/cotacao")
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if body := decode(t, rec); body["bid"] != "5.4300" {
+				t.Errorf("bid = %v, want 5.4300", body["bid"])
+			}
+			stored, err := h.Repository.Latest(context.Background())
+			if err != nil {
+				t.Fatalf("Latest: %v", err)
+			}
+			if stored.Bid != "5.4300" {
+				t.Errorf("stored bid = %q, want 5.4300", stored.Bid)
+			}
+		})
+	}
+}
+
+func TestAwesomeAPIExtractorRejectsOtherBidTypes(t *testing.T) {
+	for _, payload := range []string{
+		`{"USDBRL":{"bid":true}}`,
+		`{"USDBRL":{"bid":{"value":"5.43"}}}`,
+		`{"USDBRL":{"bid":null}}`,
+	} {
+		if got, err := cotacao.AwesomeAPIExtractor([]byte(payload), cotacao.DefaultPair); err == nil {
+			t.Errorf("AwesomeAPIExtractor(%s) = %+v, want an error", payload, got)
+		}
+	}
+}
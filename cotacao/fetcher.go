@@ -0,0 +1,679 @@
+package cotacao
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Cotacao is a quote as sent by the upstream.
+type Cotacao struct {
+	Bid       string `json:"bid"`
+	Ask       string `json:"ask"`
+	High      string `json:"high"`
+	Low       string `json:"low"`
+	VarBid    string `json:"varBid"`
+	PctChange string `json:"pctChange"`
+}
+
+const DefaultPair = "USD-BRL"
+
+// DefaultMaxBodySize is the upstream body limit used unless WithMaxBodySize
+// sets another.
+const DefaultMaxBodySize = 1 << 20
+
+var (
+	ErrUnknownPair = errors.New("unknown currency pair")
+	ErrMissingPair = errors.New("pair missing from upstream response")
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrBodyTooLarge        = errors.New("upstream response body too large")
+	ErrDecodeFailed        = errors.New("decoding upstream response failed")
+	ErrProbeUnsupported    = errors.New("fetcher cannot probe its upstream")
+	pairPattern            = regexp.MustCompile(`^[A-Z]{3}-[A-Z]{3}$`)
+)
+
+// Sources reported in FetchResult.
+const (
+	SourceLive     = "live"
+	SourceFallback = "fallback"
+	SourceCache    = "cache"
+	SourceManual   = "manual"
+	SourceFile     = "file"
+)
+
+// FetchResult is a bid together with the path that produced it. Quote holds
+// the full upstream quote when the bid was fetched live. Latency is how long
+// the fetch took, retries included; it is zero for results served from
+// memory.
+type FetchResult struct {
+	Bid     string
+	Source  string
+	Quote   Cotacao
+	Latency time.Duration
+}
+
+type CotacaoFetcher interface {
+	Fetch(ctx context.Context, pair string) (FetchResult, error)
+}
+
+type FallbackPolicy int
+
+// The policy applies once every WithFallbackProviders provider has failed.
+const (
+	// FallbackStatic serves the configured fallback value.
+	FallbackStatic FallbackPolicy = iota
+	// FallbackLastKnown serves the last bid fetched for the pair, or the
+	// static value when none was fetched yet.
+	FallbackLastKnown
+	// FallbackError serves nothing and returns ErrUpstreamUnavailable.
+	FallbackError
+)
+
+// FailureCategory classifies a failed attempt for the circuit breaker.
+type FailureCategory int
+
+const (
+	// FailureConnection is a transport error other than a timeout, such as a
+	// DNS failure or a refused connection.
+	FailureConnection FailureCategory = iota
+	// FailureTimeout is an attempt that ran out of time.
+	FailureTimeout
+	// FailureStatus is a non-2xx response.
+	FailureStatus
+	// FailureDecode is a response whose body could not be read or holds no
+	// bid, which usually means the upstream contract changed.
+	FailureDecode
+)
+
+func (c FailureCategory) String() string {
+	switch c {
+	case FailureTimeout:
+		return "timeout"
+	case FailureStatus:
+		return "status"
+	case FailureDecode:
+		return "decode"
+	default:
+		return "connection"
+	}
+}
+
+// transportFailure tells timeouts apart from other transport errors.
+func transportFailure(err error) FailureCategory {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FailureTimeout
+	}
+	return FailureConnection
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitState struct {
+	Open         bool
+	HalfOpen     bool
+	FailureCount int
+	ResetIn      time.Duration
+}
+
+func (s CircuitState) String() string {
+	switch {
+	case s.Open:
+		return "open"
+	case s.HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type CircuitStateReporter interface {
+	State() CircuitState
+}
+
+// UpstreamProber is implemented by fetchers that can check their upstream is
+// reachable without fetching a quote. Wrappers whose fetcher cannot return
+// ErrProbeUnsupported.
+type UpstreamProber interface {
+	Probe(ctx context.Context) error
+}
+
+// stateOf reports the circuit state of f, or a closed circuit when f keeps
+// none.
+func stateOf(f CotacaoFetcher) CircuitState {
+	if reporter, ok := f.(CircuitStateReporter); ok {
+		return reporter.State()
+	}
+	return CircuitState{}
+}
+
+// probeOf probes the upstream of f, or returns ErrProbeUnsupported when f
+// cannot.
+func probeOf(ctx context.Context, f CotacaoFetcher) error {
+	if prober, ok := f.(UpstreamProber); ok {
+		return prober.Probe(ctx)
+	}
+	return ErrProbeUnsupported
+}
+
+// Clock tells the circuit breaker the time; tests can swap in a
+// cotacaotest.FakeClock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+type Backoff struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+}
+
+// delay returns a full-jitter delay for the given retry, picked uniformly
+// between zero and the exponentially grown (and capped) base delay.
+func (b Backoff) delay(retry int) time.Duration {
+	if b.BaseDelay <= 0 {
+		return 0
+	}
+
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	d := float64(b.BaseDelay) * math.Pow(multiplier, float64(retry))
+	if b.MaxDelay > 0 && d > float64(b.MaxDelay) {
+		d = float64(b.MaxDelay)
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// attemptContext bounds one attempt to an equal share of the time left before
+// ctx's deadline, so a slow upstream cannot use up the budget of the attempts
+// after it.
+func attemptContext(ctx context.Context, attemptsLeft int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || attemptsLeft <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(attemptsLeft))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type ApiCotacaoFetcher struct {
+	url              string
+	retry            int
+	retryMaxElapsed  time.Duration
+	failureThreshold int
+	failureCount     int
+	sharedFailures   int
+	// categoryThresholds holds the categories counted on their own, against
+	// their own threshold, instead of towards failureThreshold.
+	categoryThresholds map[FailureCategory]int
+	categoryFailures   map[FailureCategory]int
+	state              breakerState
+	// circuitMutex guards the failure counts, state, lastAttemptTime and
+	// lastKnown, which concurrent Fetch calls all update.
+	circuitMutex     sync.Mutex
+	lastAttemptTime  time.Time
+	circuitResetTime time.Duration
+	fallbackValue    string
+	fallbackPolicy   FallbackPolicy
+	fallbacks        []CotacaoFetcher
+	lastKnown        map[string]string
+	backoff          Backoff
+	client           *http.Client
+	metrics          *FetcherMetrics
+	extractor        Extractor
+	clock            Clock
+	maxBodySize      int64
+	headers          http.Header
+}
+
+type Option func(*ApiCotacaoFetcher)
+
+func WithRetry(n int) Option {
+	return func(f *ApiCotacaoFetcher) { f.retry = n }
+}
+
+// WithRetryMaxElapsed stops retrying once d has passed since the first
+// attempt, counting the backoff before the next one, even if retries remain.
+// Zero means no limit.
+func WithRetryMaxElapsed(d time.Duration) Option {
+	return func(f *ApiCotacaoFetcher) { f.retryMaxElapsed = d }
+}
+
+func WithFailureThreshold(n int) Option {
+	return func(f *ApiCotacaoFetcher) { f.failureThreshold = n }
+}
+
+// WithCategoryThreshold opens the circuit after n consecutive failures of
+// category, counted apart from the other categories, which keep sharing the
+// WithFailureThreshold count.
+func WithCategoryThreshold(category FailureCategory, n int) Option {
+	return func(f *ApiCotacaoFetcher) { f.categoryThresholds[category] = n }
+}
+
+func WithResetTime(d time.Duration) Option {
+	return func(f *ApiCotacaoFetcher) { f.circuitResetTime = d }
+}
+
+func WithFallback(value string) Option {
+	return func(f *ApiCotacaoFetcher) { f.fallbackValue = value }
+}
+
+func WithFallbackPolicy(policy FallbackPolicy) Option {
+	return func(f *ApiCotacaoFetcher) { f.fallbackPolicy = policy }
+}
+
+// WithFallbackProviders sets fetchers, such as a FileCotacaoFetcher reading a
+// local copy, that are asked in order for a bid when the upstream fails. The
+// first bid one yields is served as a fallback; when all fail, the fallback
+// policy decides.
+func WithFallbackProviders(providers ...CotacaoFetcher) Option {
+	return func(f *ApiCotacaoFetcher) { f.fallbacks = providers }
+}
+
+func WithBackoff(backoff Backoff) Option {
+	return func(f *ApiCotacaoFetcher) { f.backoff = backoff }
+}
+
+func WithTimeout(d time.Duration) Option {
+	return func(f *ApiCotacaoFetcher) { f.client.Timeout = d }
+}
+
+func WithTransport(transport *http.Transport) Option {
+	return func(f *ApiCotacaoFetcher) { f.client.Transport = transport }
+}
+
+func WithExtractor(extractor Extractor) Option {
+	return func(f *ApiCotacaoFetcher) { f.extractor = extractor }
+}
+
+// WithMaxBodySize caps how many bytes of an upstream body are read, after
+// decompression. Larger bodies fail the attempt with ErrBodyTooLarge.
+func WithMaxBodySize(n int64) Option {
+	return func(f *ApiCotacaoFetcher) { f.maxBodySize = n }
+}
+
+// WithHeaders sets headers, such as an API key or User-Agent, on every
+// upstream request.
+func WithHeaders(headers http.Header) Option {
+	return func(f *ApiCotacaoFetcher) { f.headers = headers.Clone() }
+}
+
+func WithClock(clock Clock) Option {
+	return func(f *ApiCotacaoFetcher) { f.clock = clock }
+}
+
+func WithMetrics(metrics *FetcherMetrics) Option {
+	return func(f *ApiCotacaoFetcher) { f.metrics = metrics }
+}
+
+// NewApiCotacaoFetcher returns an error when the fallback value is not a
+// positive number, so a typo is not served and persisted as a quote.
+func NewApiCotacaoFetcher(url string, opts ...Option) (CotacaoFetcher, error) {
+	f := &ApiCotacaoFetcher{
+		url:                url,
+		retry:              3,
+		failureThreshold:   2,
+		categoryThresholds: make(map[FailureCategory]int),
+		categoryFailures:   make(map[FailureCategory]int),
+		circuitResetTime:   2 * time.Second,
+		fallbackValue:      "1.00",
+		lastKnown:          make(map[string]string),
+		extractor:          AwesomeAPIExtractor,
+		clock:              realClock{},
+		maxBodySize:        DefaultMaxBodySize,
+		client: &http.Client{
+			Timeout: time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:    10,
+				IdleConnTimeout: 90 * time.Second,
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if _, err := parseBid(f.fallbackValue); err != nil {
+		return nil, fmt.Errorf("invalid fallback value: %w", err)
+	}
+	return f, nil
+}
+
+func (f *ApiCotacaoFetcher) Fetch(ctx context.Context, pair string) (result FetchResult, err error) {
+	ctx, span := tracer.Start(ctx, "ApiCotacaoFetcher.Fetch", trace.WithAttributes(attribute.String("cotacao.pair", pair)))
+	defer func() { endSpan(span, err) }()
+	began := time.Now()
+	defer func() { result.Latency = time.Since(began) }()
+
+	retry := f.retry
+	f.circuitMutex.Lock()
+	span.SetAttributes(attribute.String("circuit.state", f.state.String()))
+	switch {
+	case f.state == breakerOpen && f.clock.Now().Sub(f.lastAttemptTime) < f.circuitResetTime:
+		f.circuitMutex.Unlock()
+		slog.InfoContext(ctx, "Circuit breaker is open, using fallback", "pair", pair)
+		return f.fallback(ctx, pair, ErrCircuitOpen)
+	case f.state == breakerOpen:
+		slog.WarnContext(ctx, "Circuit breaker half-open after cooldown, allowing a probe")
+		f.state = breakerHalfOpen
+		retry = 0
+		defer f.endProbe()
+	case f.state == breakerHalfOpen:
+		f.circuitMutex.Unlock()
+		slog.InfoContext(ctx, "Circuit breaker is half-open with a probe in flight, using fallback", "pair", pair)
+		return f.fallback(ctx, pair, ErrCircuitOpen)
+	}
+	f.circuitMutex.Unlock()
+
+	var lastErr error
+	cancelAttempt := context.CancelFunc(func() {})
+	defer func() { cancelAttempt() }()
+	firstAttempt := time.Now()
+	for i := 0; i <= retry; i++ {
+		if i > 0 {
+			delay := f.backoff.delay(i - 1)
+			if f.retryMaxElapsed > 0 && time.Since(firstAttempt)+delay >= f.retryMaxElapsed {
+				slog.WarnContext(ctx, "Retry time budget spent, giving up", "pair", pair, "attempts", i, "budget", f.retryMaxElapsed)
+				break
+			}
+			if err := sleepContext(ctx, delay); err != nil {
+				lastErr = err
+				break
+			}
+		}
+
+		span.SetAttributes(attribute.Int("fetch.attempt", i+1))
+
+		cancelAttempt()
+		var attemptCtx context.Context
+		attemptCtx, cancelAttempt = attemptContext(ctx, retry-i+1)
+
+		req, err := http.NewRequestWithContext(attemptCtx, "GET", f.url+pair, nil)
+		if err != nil {
+			return FetchResult{}, err
+		}
+		for name, values := range f.headers {
+			req.Header[name] = values
+		}
+
+		start := time.Now()
+		resp, err := f.client.Do(req)
+		f.metrics.observeLatency(time.Since(start))
+		if err != nil && ctx.Err() != nil {
+			// The caller gave up; that says nothing about upstream health.
+			lastErr = err
+			break
+		}
+		if err != nil {
+			lastErr = err
+			slog.WarnContext(ctx, "Fetch attempt failed", "pair", pair, "attempt", i+1, "error", err)
+			if f.incrementFailureCount(ctx, transportFailure(err)) {
+				break
+			}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			closeBody(resp)
+			return FetchResult{}, fmt.Errorf("%w: %s", ErrUnknownPair, pair)
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			closeBody(resp)
+			lastErr = fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			slog.WarnContext(ctx, "Fetch attempt failed", "pair", pair, "attempt", i+1, "status", resp.StatusCode)
+			if f.incrementFailureCount(ctx, FailureStatus) {
+				break
+			}
+			continue
+		}
+
+		quote, err := f.decodeQuote(resp, pair)
+		if err != nil && ctx.Err() != nil {
+			lastErr = err
+			break
+		}
+		if err != nil {
+			lastErr = err
+			slog.WarnContext(ctx, "Fetch attempt failed during decoding", "pair", pair, "attempt", i+1, "error", err)
+			if f.incrementFailureCount(ctx, FailureDecode) {
+				break
+			}
+			continue
+		}
+
+		f.resetCircuit(ctx)
+		f.circuitMutex.Lock()
+		f.lastKnown[pair] = quote.Bid
+		f.circuitMutex.Unlock()
+		slog.DebugContext(ctx, "Fetch succeeded", "pair", pair, "attempt", i+1, "bid", quote.Bid)
+		return FetchResult{Bid: quote.Bid, Source: SourceLive, Quote: quote}, nil
+	}
+
+	f.circuitMutex.Lock()
+	f.lastAttemptTime = f.clock.Now()
+	f.circuitMutex.Unlock()
+	slog.ErrorContext(ctx, "All fetch attempts failed, using fallback", "pair", pair, "error", lastErr)
+	span.RecordError(lastErr)
+	return f.fallback(ctx, pair, lastErr)
+}
+
+// fallback returns the value to serve instead of a live bid: the first bid a
+// fallback provider yields or, failing that, the one the fallback policy
+// picks. Only under FallbackError is there an error, ErrUpstreamUnavailable
+// wrapping cause; the other policies leave cause to the logs.
+func (f *ApiCotacaoFetcher) fallback(ctx context.Context, pair string, cause error) (FetchResult, error) {
+	for i, provider := range f.fallbacks {
+		result, providerErr := provider.Fetch(ctx, pair)
+		if providerErr == nil && result.Bid != "" {
+			slog.InfoContext(ctx, "Serving bid from fallback provider", "pair", pair, "provider", i)
+			f.metrics.incFallback()
+			return FetchResult{Bid: result.Bid, Source: SourceFallback, Quote: result.Quote}, nil
+		}
+		slog.WarnContext(ctx, "Fallback provider failed", "pair", pair, "provider", i, "error", providerErr)
+	}
+
+	switch f.fallbackPolicy {
+	case FallbackError:
+		return FetchResult{}, fmt.Errorf("%w: %w", ErrUpstreamUnavailable, cause)
+	case FallbackLastKnown:
+		f.circuitMutex.Lock()
+		bid, ok := f.lastKnown[pair]
+		f.circuitMutex.Unlock()
+		if ok {
+			f.metrics.incFallback()
+			return FetchResult{Bid: bid, Source: SourceFallback}, nil
+		}
+	}
+	f.metrics.incFallback()
+	return FetchResult{Bid: f.fallbackValue, Source: SourceFallback}, nil
+}
+
+func (f *ApiCotacaoFetcher) State() CircuitState {
+	f.circuitMutex.Lock()
+	defer f.circuitMutex.Unlock()
+
+	state := CircuitState{
+		Open:         f.state == breakerOpen,
+		HalfOpen:     f.state == breakerHalfOpen,
+		FailureCount: f.failureCount,
+	}
+	if state.Open {
+		if remaining := f.circuitResetTime - f.clock.Now().Sub(f.lastAttemptTime); remaining > 0 {
+			state.ResetIn = remaining
+		}
+	}
+	return state
+}
+
+// Probe sends a HEAD request for DefaultPair, bypassing retries and the
+// circuit breaker. Any answer but a server error counts as reachable, and so
+// does 501, since some upstreams refuse HEAD while serving GET.
+func (f *ApiCotacaoFetcher) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, f.url+DefaultPair, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range f.headers {
+		req.Header[name] = values
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	closeBody(resp)
+	if resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode != http.StatusNotImplemented {
+		return fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	return nil
+}
+
+// closeBody drains and closes the response body so the underlying connection
+// can be reused by keep-alive.
+func closeBody(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// decodeQuote extracts the quote from the response body, wrapping any failure
+// in ErrDecodeFailed. The transport already decompresses gzip it asked for
+// itself; bodies that arrive gzip-encoded anyway are decompressed here.
+func (f *ApiCotacaoFetcher) decodeQuote(resp *http.Response, pair string) (quote Cotacao, err error) {
+	defer closeBody(resp)
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrDecodeFailed, err)
+		}
+	}()
+
+	var reader io.Reader = resp.Body
+	if !resp.Uncompressed && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return Cotacao{}, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, f.maxBodySize+1))
+	if err != nil {
+		return Cotacao{}, err
+	}
+	if int64(len(body)) > f.maxBodySize {
+		return Cotacao{}, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, f.maxBodySize)
+	}
+	quote, err = f.extractor(body, pair)
+	if err == nil && quote.Bid == "" {
+		err = fmt.Errorf("%w: empty bid", ErrMissingPair)
+	}
+	return quote, err
+}
+
+// incrementFailureCount counts a failed attempt and reports whether the
+// circuit is open afterwards, in which case Fetch stops retrying.
+func (f *ApiCotacaoFetcher) incrementFailureCount(ctx context.Context, category FailureCategory) bool {
+	f.circuitMutex.Lock()
+	defer f.circuitMutex.Unlock()
+	f.failureCount++
+	count, threshold := 0, f.failureThreshold
+	if limit, ok := f.categoryThresholds[category]; ok {
+		f.categoryFailures[category]++
+		count, threshold = f.categoryFailures[category], limit
+	} else {
+		f.sharedFailures++
+		count = f.sharedFailures
+	}
+	f.metrics.incFailure()
+	if f.state == breakerHalfOpen {
+		f.state = breakerOpen
+		f.metrics.incCircuitOpen()
+		slog.WarnContext(ctx, "Circuit breaker probe failed, re-opening")
+		return true
+	}
+	if count >= threshold && f.state != breakerOpen {
+		f.metrics.incCircuitOpen()
+		f.state = breakerOpen
+		slog.WarnContext(ctx, "Circuit breaker opened", "failures", f.failureCount, "category", category.String())
+	}
+	return f.state == breakerOpen
+}
+
+func (f *ApiCotacaoFetcher) resetCircuit(ctx context.Context) {
+	f.circuitMutex.Lock()
+	defer f.circuitMutex.Unlock()
+	if f.state == breakerHalfOpen {
+		slog.WarnContext(ctx, "Circuit breaker probe succeeded, closing")
+	}
+	f.failureCount = 0
+	f.sharedFailures = 0
+	clear(f.categoryFailures)
+	f.state = breakerClosed
+	f.metrics.incSuccess()
+}
+
+// endProbe re-opens the circuit when a half-open probe returned without
+// closing it, e.g. because the context was cancelled before the attempt.
+func (f *ApiCotacaoFetcher) endProbe() {
+	f.circuitMutex.Lock()
+	defer f.circuitMutex.Unlock()
+	if f.state == breakerHalfOpen {
+		f.state = breakerOpen
+		f.lastAttemptTime = f.clock.Now()
+	}
+}
@@ -0,0 +1,893 @@
+package cotacao_test
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+// upstream starts a server answering every request with handler and closes
+// it with the test.
+func upstream(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func servePayload(payload string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, payload)
+	}
+}
+
+// newFetcher builds an ApiCotacaoFetcher for url without backoff delays, so
+// retries run back to back.
+func newFetcher(t *testing.T, url string, opts ...cotacao.Option) cotacao.CotacaoFetcher {
+	t.Helper()
+	opts = append([]cotacao.Option{cotacao.WithBackoff(cotacao.Backoff{})}, opts...)
+	fetcher, err := cotacao.NewApiCotacaoFetcher(url, opts...)
+	if err != nil {
+		t.Fatalf("NewApiCotacaoFetcher: %v", err)
+	}
+	return fetcher
+}
+
+// failingTransport fails every dial, so client.Do returns (nil, err).
+func failingTransport() *http.Transport {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, errors.New("dial refused")
+		},
+	}
+}
+
+func TestFetchTransportErrorFallsBack(t *testing.T) {
+	fetcher := newFetcher(t, "http://upstream.invalid/json/last/",
+		cotacao.WithTransport(failingTransport()),
+		cotacao.WithRetry(2),
+		cotacao.WithFailureThreshold(10),
+		cotacao.WithFallback("4.20"),
+	)
+
+	result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+	if err != nil || result.Bid != "4.20" || result.Source != cotacao.SourceFallback {
+		t.Errorf("result = %+v, %v; want the 4.20 fallback without an error", result, err)
+	}
+	state := fetcher.(cotacao.CircuitStateReporter).State()
+	if state.FailureCount != 3 {
+		t.Errorf("FailureCount = %d, want 3 (one per attempt)", state.FailureCount)
+	}
+}
+
+func TestFetchReleasesConnections(t *testing.T) {
+	var mu sync.Mutex
+	opened := 0
+	open := make(map[net.Conn]bool)
+	srv := httptest.NewUnstartedServer(servePayload(cotacaotest.AwesomeAPIPayload))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch state {
+		case http.StateNew:
+			opened++
+			open[conn] = true
+		case http.StateClosed, http.StateHijacked:
+			delete(open, conn)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	transport := &http.Transport{MaxIdleConns: 10, IdleConnTimeout: time.Minute}
+	fetcher := newFetcher(t, srv.URL+"/json/last/", cotacao.WithTransport(transport))
+	for i := 0; i < 100; i++ {
+		if _, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair); err != nil {
+			t.Fatalf("fetch %d: %v", i, err)
+		}
+	}
+	transport.CloseIdleConnections()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		remaining, total := len(open), opened
+		mu.Unlock()
+		if remaining == 0 {
+			if total != 1 {
+				t.Errorf("opened %d connections for 100 sequential fetches, want 1 reused", total)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections still open after 100 fetches", remaining)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFetchSendsConfiguredHeaders(t *testing.T) {
+	got := make(chan http.Header, 1)
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		servePayload(cotacaotest.AwesomeAPIPayload)(w, r)
+	})
+	headers := http.Header{}
+	headers.Set("X-API-Key", "secret")
+	headers.Set("User-Agent", "cotacao-test/1.0")
+	fetcher := newFetcher(t, srv.URL+"/json/last/", cotacao.WithHeaders(headers))
+	// Headers are copied, so later changes to the caller's set are not sent.
+	headers.Set("X-API-Key", "changed")
+
+	if _, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	sent := <-got
+	if key := sent.Get("X-API-Key"); key != "secret" {
+		t.Errorf("X-API-Key = %q, want secret", key)
+	}
+	if ua := sent.Get("User-Agent"); ua != "cotacao-test/1.0" {
+		t.Errorf("User-Agent = %q, want cotacao-test/1.0", ua)
+	}
+}
+
+func TestFetchRetriesNon2xxThenFallsBack(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(2),
+		cotacao.WithFailureThreshold(10),
+	)
+
+	result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 3 {
+		t.Errorf("upstream hit %d times, want 3 (first attempt and 2 retries)", hits)
+	}
+	if err != nil || result.Bid == "" || result.Source != cotacao.SourceFallback {
+		t.Errorf("result = %+v, %v; want the fallback bid without an error", result, err)
+	}
+}
+
+func TestFetchClientTimeout(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithTimeout(20*time.Millisecond),
+		cotacao.WithRetry(0),
+	)
+
+	start := time.Now()
+	result, _ := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Fetch took %s, want it cut short by the 20ms client timeout", elapsed)
+	}
+	if result.Source != cotacao.SourceFallback {
+		t.Errorf("source = %q, want %q", result.Source, cotacao.SourceFallback)
+	}
+}
+
+func TestFetchRespectsOverallDeadline(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		<-r.Context().Done()
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithTimeout(time.Minute),
+		cotacao.WithRetry(3),
+		cotacao.WithFailureThreshold(10),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, _ := fetcher.Fetch(ctx, cotacao.DefaultPair)
+	elapsed := time.Since(start)
+
+	if elapsed > 300*time.Millisecond {
+		t.Errorf("Fetch took %s, want it within the 200ms deadline", elapsed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if hits != 4 {
+		t.Errorf("upstream hit %d times, want all 4 attempts to fit in the deadline", hits)
+	}
+	if result.Source != cotacao.SourceFallback {
+		t.Errorf("source = %q, want %q", result.Source, cotacao.SourceFallback)
+	}
+}
+
+func TestFetchRetryMaxElapsed(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		time.Sleep(40 * time.Millisecond)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(10),
+		cotacao.WithFailureThreshold(20),
+		cotacao.WithRetryMaxElapsed(100*time.Millisecond),
+	)
+
+	start := time.Now()
+	result, _ := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+	elapsed := time.Since(start)
+
+	// Attempts start at about 0, 40ms and 80ms; the budget is spent before a
+	// fourth one at 120ms.
+	mu.Lock()
+	defer mu.Unlock()
+	if hits < 2 || hits > 3 {
+		t.Errorf("upstream hit %d times, want the 100ms budget to stop retries after 2 or 3 of the 11 allowed", hits)
+	}
+	if elapsed > 250*time.Millisecond {
+		t.Errorf("Fetch took %s, want it to stop near the 100ms budget", elapsed)
+	}
+	if result.Source != cotacao.SourceFallback {
+		t.Errorf("source = %q, want %q", result.Source, cotacao.SourceFallback)
+	}
+}
+
+func TestFetchGzipUpstream(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		fmt.Fprint(gz, cotacaotest.AwesomeAPIPayload)
+	})
+
+	tests := []struct {
+		name      string
+		transport *http.Transport
+	}{
+		// The transport asks for gzip itself and decompresses transparently.
+		{"transparent", &http.Transport{}},
+		// The body arrives compressed and the fetcher decompresses it.
+		{"compression disabled", &http.Transport{DisableCompression: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := newFetcher(t, srv.URL+"/json/last/", cotacao.WithTransport(tt.transport), cotacao.WithRetry(0))
+
+			result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+			if err != nil || result.Bid != "5.4321" || result.Source != cotacao.SourceLive {
+				t.Errorf("Fetch = %+v, %v; want live bid 5.4321", result, err)
+			}
+		})
+	}
+}
+
+func TestFetchBodyTooLarge(t *testing.T) {
+	// The payload is valid JSON, padded past the limit.
+	payload := `{"USDBRL":{"bid":"5.4321","padding":"` + strings.Repeat("x", 2048) + `"}}`
+	srv := upstream(t, servePayload(payload))
+
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr error
+	}{
+		{"over the limit", 1024, cotacao.ErrBodyTooLarge},
+		{"within the limit", int64(len(payload)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := newFetcher(t, srv.URL+"/json/last/",
+				cotacao.WithMaxBodySize(tt.limit),
+				cotacao.WithRetry(0),
+				cotacao.WithFailureThreshold(10),
+				cotacao.WithFallbackPolicy(cotacao.FallbackError),
+			)
+
+			result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+			failures := fetcher.(cotacao.CircuitStateReporter).State().FailureCount
+
+			if tt.wantErr == nil {
+				if err != nil || result.Bid != "5.4321" || failures != 0 {
+					t.Errorf("Fetch = %+v, %v with %d failures; want live bid 5.4321", result, err, failures)
+				}
+				return
+			}
+			if !errors.Is(err, cotacao.ErrUpstreamUnavailable) || !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v wrapping %v", err, cotacao.ErrUpstreamUnavailable, tt.wantErr)
+			}
+			if failures != 1 {
+				t.Errorf("failure count = %d, want the oversized body counted once", failures)
+			}
+		})
+	}
+}
+
+// BenchmarkFetch reuses one fetcher, and so its client and idle connections,
+// across fetches.
+func BenchmarkFetch(b *testing.B) {
+	srv := httptest.NewServer(servePayload(cotacaotest.AwesomeAPIPayload))
+	defer srv.Close()
+	fetcher, err := cotacao.NewApiCotacaoFetcher(srv.URL + "/json/last/")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFetchNewClient builds a fetcher, and so a client and transport,
+// per fetch, as Fetch used to per attempt; compare with BenchmarkFetch.
+func BenchmarkFetchNewClient(b *testing.B) {
+	srv := httptest.NewServer(servePayload(cotacaotest.AwesomeAPIPayload))
+	defer srv.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transport := &http.Transport{}
+		fetcher, err := cotacao.NewApiCotacaoFetcher(srv.URL+"/json/last/", cotacao.WithTransport(transport))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair); err != nil {
+			b.Fatal(err)
+		}
+		transport.CloseIdleConnections()
+	}
+}
+
+func TestFetchBackoffWindow(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(3),
+		cotacao.WithFailureThreshold(10),
+		cotacao.WithBackoff(cotacao.Backoff{BaseDelay: 20 * time.Millisecond, MaxDelay: time.Second, Multiplier: 2}),
+	)
+
+	start := time.Now()
+	fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+	elapsed := time.Since(start)
+
+	// Full jitter sleeps up to 20ms, 40ms and 80ms before the three retries.
+	if limit := 140*time.Millisecond + 100*time.Millisecond; elapsed > limit {
+		t.Errorf("3 retries took %s, want at most the 140ms backoff window plus slack", elapsed)
+	}
+}
+
+func TestFetchBackoffHonoursCancellation(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(3),
+		cotacao.WithFailureThreshold(10),
+		cotacao.WithBackoff(cotacao.Backoff{BaseDelay: time.Minute, MaxDelay: time.Minute, Multiplier: 2}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	fetcher.Fetch(ctx, cotacao.DefaultPair)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Fetch slept %s past a 50ms deadline, want the backoff cut short", elapsed)
+	}
+}
+
+// breakerUpstream fails while failing is set and otherwise serves
+// AwesomeAPIPayload, counting hits and recording the fetcher's state as seen
+// during each one.
+type breakerUpstream struct {
+	mu      sync.Mutex
+	failing bool
+	hits    int
+	seen    []string
+	fetcher cotacao.CotacaoFetcher
+}
+
+func (u *breakerUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	u.hits++
+	u.seen = append(u.seen, u.fetcher.(cotacao.CircuitStateReporter).State().String())
+	failing := u.failing
+	u.mu.Unlock()
+	if failing {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	servePayload(cotacaotest.AwesomeAPIPayload)(w, r)
+}
+
+func (u *breakerUpstream) set(failing bool) (hits int, seen []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failing = failing
+	hits, seen = u.hits, u.seen
+	u.hits, u.seen = 0, nil
+	return hits, seen
+}
+
+func TestFetchCircuitStateMachine(t *testing.T) {
+	up := &breakerUpstream{failing: true}
+	srv := upstream(t, up.ServeHTTP)
+	clock := cotacaotest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithClock(clock),
+		cotacao.WithRetry(3),
+		cotacao.WithFailureThreshold(2),
+		cotacao.WithResetTime(time.Minute),
+	)
+	up.fetcher = fetcher
+	state := func() string { return fetcher.(cotacao.CircuitStateReporter).State().String() }
+	fetch := func() cotacao.FetchResult {
+		t.Helper()
+		result, _ := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+		return result
+	}
+
+	// closed → open: retries stop at the failure that opens the circuit.
+	fetch()
+	if hits, _ := up.set(true); hits != 2 || state() != "open" {
+		t.Fatalf("after failing: %d hits, circuit %s; want 2 hits and open", hits, state())
+	}
+	if result := fetch(); result.Source != cotacao.SourceFallback {
+		t.Errorf("while open: source %q, want %q", result.Source, cotacao.SourceFallback)
+	}
+	if hits, _ := up.set(false); hits != 0 {
+		t.Errorf("while open: upstream hit %d times, want 0", hits)
+	}
+
+	// open → half-open → closed: one probe after the cooldown succeeds.
+	clock.Advance(time.Minute)
+	if result := fetch(); result.Source != cotacao.SourceLive {
+		t.Errorf("probe after cooldown: source %q, want %q", result.Source, cotacao.SourceLive)
+	}
+	if hits, seen := up.set(true); hits != 1 || seen[0] != "half-open" || state() != "closed" {
+		t.Errorf("probe: %d hits seeing %v, circuit %s; want 1 half-open probe and closed", hits, seen, state())
+	}
+
+	// open → half-open → open: a failed probe re-opens without retrying.
+	fetch()
+	up.set(true)
+	clock.Advance(time.Minute)
+	fetch()
+	if hits, seen := up.set(true); hits != 1 || seen[0] != "half-open" || state() != "open" {
+		t.Errorf("failed probe: %d hits seeing %v, circuit %s; want 1 half-open probe and open", hits, seen, state())
+	}
+}
+
+func TestFetchCircuitCooldownFollowsClock(t *testing.T) {
+	up := &breakerUpstream{failing: true}
+	srv := upstream(t, up.ServeHTTP)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := cotacaotest.NewFakeClock(start)
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithClock(clock),
+		cotacao.WithRetry(0),
+		cotacao.WithFailureThreshold(1),
+		cotacao.WithResetTime(time.Hour),
+	)
+	up.fetcher = fetcher
+	reporter := fetcher.(cotacao.CircuitStateReporter)
+
+	fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+	up.set(false)
+	if state := reporter.State(); !state.Open || state.ResetIn != time.Hour {
+		t.Fatalf("after the failure: %s resetting in %s, want open for the 1h reset time", state, state.ResetIn)
+	}
+
+	clock.Advance(time.Hour - time.Second)
+	if state := reporter.State(); !state.Open || state.ResetIn != time.Second {
+		t.Errorf("1s before the cooldown ends: %s resetting in %s, want open for 1s more", state, state.ResetIn)
+	}
+	if result, _ := fetcher.Fetch(context.Background(), cotacao.DefaultPair); result.Source != cotacao.SourceFallback {
+		t.Errorf("before the cooldown ends: source %q, want %q", result.Source, cotacao.SourceFallback)
+	}
+	if hits, _ := up.set(false); hits != 0 {
+		t.Errorf("before the cooldown ends: upstream hit %d times, want 0", hits)
+	}
+
+	clock.Advance(time.Second)
+	if result, _ := fetcher.Fetch(context.Background(), cotacao.DefaultPair); result.Source != cotacao.SourceLive {
+		t.Errorf("once the cooldown ends: source %q, want %q", result.Source, cotacao.SourceLive)
+	}
+	if state := reporter.State(); state.Open || state.FailureCount != 0 {
+		t.Errorf("after the probe: %s with %d failures, want closed with 0", state, state.FailureCount)
+	}
+	if now := clock.Now(); !now.Equal(start.Add(time.Hour)) {
+		t.Errorf("clock at %s, want it moved only by Advance to %s", now, start.Add(time.Hour))
+	}
+}
+
+func TestFetchCategoryThresholds(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		wantHits int
+	}{
+		{"decode opens after 1", servePayload(`{"USDBRL":`), 1},
+		{"timeout opens after 3", func(w http.ResponseWriter, r *http.Request) { <-r.Context().Done() }, 3},
+		{"status shares the threshold of 5", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			hits := 0
+			srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				hits++
+				mu.Unlock()
+				tt.handler(w, r)
+			})
+			fetcher := newFetcher(t, srv.URL+"/json/last/",
+				cotacao.WithTimeout(20*time.Millisecond),
+				cotacao.WithRetry(10),
+				cotacao.WithFailureThreshold(5),
+				cotacao.WithCategoryThreshold(cotacao.FailureDecode, 1),
+				cotacao.WithCategoryThreshold(cotacao.FailureTimeout, 3),
+			)
+
+			fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if state := fetcher.(cotacao.CircuitStateReporter).State(); hits != tt.wantHits || !state.Open {
+				t.Errorf("%d hits, circuit %s; want open after %d", hits, state, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestFetchErrorsWrapSentinels(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    []error
+	}{
+		{"non-2xx", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}, []error{cotacao.ErrUpstreamUnavailable}},
+		{"malformed body", servePayload(`{"USDBRL":`), []error{cotacao.ErrUpstreamUnavailable, cotacao.ErrDecodeFailed}},
+		{"missing pair", servePayload(`{"OTHER":{"bid":"1"}}`), []error{cotacao.ErrUpstreamUnavailable, cotacao.ErrDecodeFailed, cotacao.ErrMissingPair}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := upstream(t, tt.handler)
+			fetcher := newFetcher(t, srv.URL+"/json/last/",
+				cotacao.WithRetry(0),
+				cotacao.WithFailureThreshold(1),
+				cotacao.WithResetTime(time.Minute),
+				cotacao.WithFallbackPolicy(cotacao.FallbackError),
+			)
+
+			_, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+			for _, want := range tt.want {
+				if !errors.Is(err, want) {
+					t.Errorf("err = %v, want it to match %v", err, want)
+				}
+			}
+
+			// The failure opened the circuit, so the next fetch is refused.
+			_, err = fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+			if !errors.Is(err, cotacao.ErrCircuitOpen) || !errors.Is(err, cotacao.ErrUpstreamUnavailable) {
+				t.Errorf("while open: err = %v, want %v wrapping %v", err, cotacao.ErrUpstreamUnavailable, cotacao.ErrCircuitOpen)
+			}
+		})
+	}
+}
+
+// TestFetchConcurrentBreaker drives the breaker through all its states from
+// many goroutines at once; run it with -race to check the shared state stays
+// behind circuitMutex.
+func TestFetchConcurrentBreaker(t *testing.T) {
+	// Quiet the logs: the handler's lock orders the goroutines and would hide
+	// some races from the detector.
+	captureLogs(t, slog.LevelError+1)
+	var hits atomic.Int32
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		// Bursts of 10 failures alternate with 10 successes.
+		if (hits.Add(1)/10)%2 == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		servePayload(cotacaotest.AwesomeAPIPayload)(w, r)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(1),
+		cotacao.WithFailureThreshold(2),
+		cotacao.WithResetTime(time.Millisecond),
+	)
+	reporter := fetcher.(cotacao.CircuitStateReporter)
+
+	const goroutines, fetches = 50, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*fetches)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < fetches; i++ {
+				result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+				if err != nil || result.Bid == "" {
+					errs <- fmt.Errorf("Fetch = %+v, %v; want a live or fallback bid", result, err)
+				}
+				reporter.State()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if hits.Load() == 0 {
+		t.Error("no fetch reached the upstream")
+	}
+}
+
+func TestFetchMissingPairCountsAsFailure(t *testing.T) {
+	srv := upstream(t, servePayload(`{"OTHER":{"bid":"1"}}`))
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(0),
+		cotacao.WithFailureThreshold(10),
+		cotacao.WithFallbackPolicy(cotacao.FallbackError),
+	)
+
+	result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+	if !errors.Is(err, cotacao.ErrMissingPair) {
+		t.Errorf("err = %v, want %v", err, cotacao.ErrMissingPair)
+	}
+	if result.Bid != "" {
+		t.Errorf("bid = %q, want none", result.Bid)
+	}
+	if n := fetcher.(cotacao.CircuitStateReporter).State().FailureCount; n != 1 {
+		t.Errorf("FailureCount = %d, want the missing pair counted", n)
+	}
+}
+
+// failingUpstream answers every request with 503 and reports how many it got.
+func failingUpstream(t *testing.T) (url string, hits func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	n := 0
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	return srv.URL + "/json/last/", func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+}
+
+func TestNewApiCotacaoFetcherDefaults(t *testing.T) {
+	url, hits := failingUpstream(t)
+	clock := cotacaotest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fetcher := newFetcher(t, url, cotacao.WithClock(clock))
+	reporter := fetcher.(cotacao.CircuitStateReporter)
+
+	result, _ := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+	if result.Bid != "1.00" || result.Source != cotacao.SourceFallback {
+		t.Errorf("result = %+v, want the default 1.00 fallback", result)
+	}
+	// The default threshold of 2 opens the circuit before the default 3
+	// retries are spent.
+	if n := hits(); n != 2 || !reporter.State().Open {
+		t.Errorf("%d hits, state %s; want 2 hits and open", n, reporter.State())
+	}
+	clock.Advance(2*time.Second - time.Nanosecond)
+	if !reporter.State().Open {
+		t.Error("circuit closed before the default 2s reset time")
+	}
+	clock.Advance(time.Nanosecond)
+	if state := reporter.State(); state.ResetIn != 0 {
+		t.Errorf("ResetIn = %s after the default 2s reset time, want 0", state.ResetIn)
+	}
+}
+
+func TestNewApiCotacaoFetcherOptions(t *testing.T) {
+	t.Run("WithRetry", func(t *testing.T) {
+		url, hits := failingUpstream(t)
+		fetcher := newFetcher(t, url, cotacao.WithRetry(4), cotacao.WithFailureThreshold(10))
+		fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+		if n := hits(); n != 5 {
+			t.Errorf("%d hits, want 5 (first attempt and 4 retries)", n)
+		}
+	})
+	t.Run("WithFailureThreshold", func(t *testing.T) {
+		url, hits := failingUpstream(t)
+		fetcher := newFetcher(t, url, cotacao.WithRetry(10), cotacao.WithFailureThreshold(3))
+		fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+		if n, state := hits(), fetcher.(cotacao.CircuitStateReporter).State(); n != 3 || !state.Open {
+			t.Errorf("%d hits, state %s; want the circuit open after 3", n, state)
+		}
+	})
+	t.Run("WithResetTime", func(t *testing.T) {
+		url, _ := failingUpstream(t)
+		clock := cotacaotest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		fetcher := newFetcher(t, url, cotacao.WithClock(clock), cotacao.WithFailureThreshold(1), cotacao.WithResetTime(time.Minute))
+		fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+		if state := fetcher.(cotacao.CircuitStateReporter).State(); state.ResetIn != time.Minute {
+			t.Errorf("ResetIn = %s, want 1m", state.ResetIn)
+		}
+	})
+	t.Run("WithFallback", func(t *testing.T) {
+		url, _ := failingUpstream(t)
+		fetcher := newFetcher(t, url, cotacao.WithFallback("4.56"))
+		if result, _ := fetcher.Fetch(context.Background(), cotacao.DefaultPair); result.Bid != "4.56" {
+			t.Errorf("bid = %q, want the 4.56 fallback", result.Bid)
+		}
+	})
+	t.Run("invalid WithFallback", func(t *testing.T) {
+		if _, err := cotacao.NewApiCotacaoFetcher("http://upstream.invalid/", cotacao.WithFallback("N/A")); err == nil {
+			t.Error("NewApiCotacaoFetcher accepted fallback N/A")
+		}
+	})
+}
+
+func TestNewApiCotacaoFetcherValidatesFallback(t *testing.T) {
+	tests := []struct {
+		fallback string
+		valid    bool
+	}{
+		{"1.00", true},
+		{"5.4321", true},
+		{"7", true},
+		{"1,00", false},
+		{"", false},
+		{"abc", false},
+		{"0", false},
+		{"-1.00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fallback, func(t *testing.T) {
+			fetcher, err := cotacao.NewApiCotacaoFetcher("http://upstream.invalid/json/last/", cotacao.WithFallback(tt.fallback))
+			if tt.valid && (err != nil || fetcher == nil) {
+				t.Errorf("NewApiCotacaoFetcher = %v, %v; want a fetcher", fetcher, err)
+			}
+			if !tt.valid && !errors.Is(err, cotacao.ErrInvalidBid) {
+				t.Errorf("err = %v, want %v", err, cotacao.ErrInvalidBid)
+			}
+		})
+	}
+}
+
+func TestFetchContextErrorsDoNotCountAsFailures(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	// With retries left, an attempt running out of its share of a deadline is
+	// a slow upstream and counts; only the caller's own deadline does not.
+	tests := []struct {
+		name   string
+		newCtx func() (context.Context, context.CancelFunc)
+	}{
+		{"canceled", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			return ctx, cancel
+		}},
+		{"deadline", func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 20*time.Millisecond)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := newFetcher(t, srv.URL+"/json/last/", cotacao.WithRetry(0), cotacao.WithFailureThreshold(1))
+			ctx, cancel := tt.newCtx()
+			defer cancel()
+
+			fetcher.Fetch(ctx, cotacao.DefaultPair)
+
+			if state := fetcher.(cotacao.CircuitStateReporter).State(); state.FailureCount != 0 || state.Open {
+				t.Errorf("state after a %s context = %s with %d failures, want closed with 0", tt.name, state, state.FailureCount)
+			}
+		})
+	}
+}
+
+func TestFetchFallbackPolicies(t *testing.T) {
+	// The upstream serves one bid, then fails.
+	newUpstream := func(t *testing.T) string {
+		var mu sync.Mutex
+		served := false
+		srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			if served {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			served = true
+			servePayload(cotacaotest.AwesomeAPIPayload)(w, r)
+		})
+		return srv.URL + "/json/last/"
+	}
+
+	tests := []struct {
+		name    string
+		policy  cotacao.FallbackPolicy
+		bid     string
+		wantErr error
+	}{
+		{"static", cotacao.FallbackStatic, "4.20", nil},
+		{"last known", cotacao.FallbackLastKnown, "5.4321", nil},
+		{"error", cotacao.FallbackError, "", cotacao.ErrUpstreamUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := newFetcher(t, newUpstream(t),
+				cotacao.WithRetry(1),
+				cotacao.WithFailureThreshold(10),
+				cotacao.WithFallback("4.20"),
+				cotacao.WithFallbackPolicy(tt.policy),
+			)
+			if result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair); err != nil || result.Source != cotacao.SourceLive {
+				t.Fatalf("first fetch = %+v, %v; want it live", result, err)
+			}
+
+			result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if result.Bid != tt.bid {
+				t.Errorf("bid = %q, want %q", result.Bid, tt.bid)
+			}
+			if tt.bid != "" && result.Source != cotacao.SourceFallback {
+				t.Errorf("source = %q, want %q", result.Source, cotacao.SourceFallback)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package cotacao
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// FileCotacaoFetcher reads quotes from a local file holding an upstream
+// response, for offline development. The file is read again on every call,
+// so edits show up on the next request.
+type FileCotacaoFetcher struct {
+	path      string
+	extractor Extractor
+}
+
+// NewFileCotacaoFetcher reads path with extractor, or AwesomeAPIExtractor
+// when extractor is nil.
+func NewFileCotacaoFetcher(path string, extractor Extractor) *FileCotacaoFetcher {
+	if extractor == nil {
+		extractor = AwesomeAPIExtractor
+	}
+	return &FileCotacaoFetcher{path: path, extractor: extractor}
+}
+
+// Probe checks that the file exists.
+func (f *FileCotacaoFetcher) Probe(ctx context.Context) error {
+	_, err := os.Stat(f.path)
+	return err
+}
+
+func (f *FileCotacaoFetcher) Fetch(ctx context.Context, pair string) (FetchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return FetchResult{}, err
+	}
+
+	body, err := os.ReadFile(f.path)
+	if err != nil {
+		return FetchResult{}, err
+	}
+	quote, err := f.extractor(body, pair)
+	if err != nil {
+		return FetchResult{}, fmt.Errorf("%w: %s: %w", ErrDecodeFailed, f.path, err)
+	}
+	if quote.Bid == "" {
+		return FetchResult{}, fmt.Errorf("%w: %w: empty bid", ErrDecodeFailed, ErrMissingPair)
+	}
+	return FetchResult{Bid: quote.Bid, Source: SourceFile, Quote: quote}, nil
+}
@@ -0,0 +1,137 @@
+package cotacao_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+func TestFileCotacaoFetcher(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		bid     string
+		wantErr error
+	}{
+		{"valid", write("valid.json", cotacaotest.AwesomeAPIPayload), "5.4321", nil},
+		{"missing", filepath.Join(dir, "missing.json"), "", fs.ErrNotExist},
+		{"malformed", write("malformed.json", `{"USDBRL":`), "", cotacao.ErrDecodeFailed},
+		{"without the pair", write("other.json", `{"EURBRL":{"bid":"6.01"}}`), "", cotacao.ErrMissingPair},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := cotacao.NewFileCotacaoFetcher(tt.path, nil).Fetch(context.Background(), cotacao.DefaultPair)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if result.Bid != tt.bid {
+				t.Errorf("bid = %q, want %q", result.Bid, tt.bid)
+			}
+			if tt.wantErr == nil && result.Source != cotacao.SourceFile {
+				t.Errorf("source = %q, want %q", result.Source, cotacao.SourceFile)
+			}
+		})
+	}
+}
+
+func TestFileCotacaoFetcherRereadsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cotacao.json")
+	fetcher := cotacao.NewFileCotacaoFetcher(path, nil)
+
+	for _, bid := range []string{"5.1111", "5.2222"} {
+		if err := os.WriteFile(path, []byte(`{"USDBRL":{"bid":"`+bid+`"}}`), 0644); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+		result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+		if err != nil || result.Bid != bid {
+			t.Errorf("Fetch after writing %s = %+v, %v; want the edited bid", bid, result, err)
+		}
+	}
+}
+
+func TestFetchWalksFallbackChain(t *testing.T) {
+	dir := t.TempDir()
+	cached := filepath.Join(dir, "cotacao.json")
+	if err := os.WriteFile(cached, []byte(`{"USDBRL":{"bid":"5.1111"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		chain func() []cotacao.CotacaoFetcher
+		bid   string
+		calls []int
+	}{
+		{
+			"first provider answers",
+			func() []cotacao.CotacaoFetcher {
+				return []cotacao.CotacaoFetcher{cotacao.NewFileCotacaoFetcher(cached, nil), &cotacaotest.StubCotacaoFetcher{Bid: "5.2222"}}
+			},
+			"5.1111",
+			[]int{0},
+		},
+		{
+			"missing file skipped",
+			func() []cotacao.CotacaoFetcher {
+				return []cotacao.CotacaoFetcher{
+					cotacao.NewFileCotacaoFetcher(filepath.Join(dir, "missing.json"), nil),
+					&cotacaotest.StubCotacaoFetcher{Bid: "5.2222"},
+					&cotacaotest.StubCotacaoFetcher{Bid: "5.3333"},
+				}
+			},
+			"5.2222",
+			[]int{1, 0},
+		},
+		{
+			"every provider fails",
+			func() []cotacao.CotacaoFetcher {
+				return []cotacao.CotacaoFetcher{
+					&cotacaotest.StubCotacaoFetcher{Err: errors.New("down")},
+					&cotacaotest.StubCotacaoFetcher{Err: errors.New("down")},
+				}
+			},
+			"9.99",
+			[]int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := tt.chain()
+			url, _ := failingUpstream(t)
+			fetcher := newFetcher(t, url, cotacao.WithRetry(0), cotacao.WithFallback("9.99"), cotacao.WithFallbackProviders(chain...))
+
+			result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+			if err != nil || result.Bid != tt.bid || result.Source != cotacao.SourceFallback {
+				t.Errorf("Fetch = %+v, %v; want fallback bid %s", result, err, tt.bid)
+			}
+			// File providers keep no count, so only the stubs are compared.
+			var got []int
+			for _, provider := range chain {
+				if stub, ok := provider.(*cotacaotest.StubCotacaoFetcher); ok {
+					got = append(got, stub.Calls())
+				}
+			}
+			if !slices.Equal(got, tt.calls) {
+				t.Errorf("stub calls = %v, want %v", got, tt.calls)
+			}
+		})
+	}
+}
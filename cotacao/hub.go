@@ -0,0 +1,126 @@
+package cotacao
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type QuoteHub struct {
+	fetcher      CotacaoFetcher
+	pair         string
+	interval     time.Duration
+	fetchTimeout time.Duration
+	upgrader     websocket.Upgrader
+	mu           sync.Mutex
+	subscribers  map[chan FetchResult]struct{}
+}
+
+func NewQuoteHub(fetcher CotacaoFetcher, pair string, interval, fetchTimeout time.Duration) *QuoteHub {
+	return &QuoteHub{
+		fetcher:      fetcher,
+		pair:         pair,
+		interval:     interval,
+		fetchTimeout: fetchTimeout,
+		subscribers:  make(map[chan FetchResult]struct{}),
+	}
+}
+
+// Run fetches the latest bid every interval and broadcasts it to the
+// connected subscribers until ctx is done. Ticks without subscribers skip the
+// upstream call.
+func (h *QuoteHub) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		h.mu.Lock()
+		idle := len(h.subscribers) == 0
+		h.mu.Unlock()
+		if idle {
+			continue
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, h.fetchTimeout)
+		result, err := h.fetcher.Fetch(fetchCtx, h.pair)
+		cancel()
+		if err != nil {
+			slog.Error("Error fetching cotacao for stream", "pair", h.pair, "error", err)
+			continue
+		}
+		h.broadcast(result)
+	}
+}
+
+func (h *QuoteHub) broadcast(result FetchResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- result:
+		default:
+			// Slow subscriber, drop this update rather than block the hub.
+		}
+	}
+}
+
+func (h *QuoteHub) subscribe() chan FetchResult {
+	ch := make(chan FetchResult, 1)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *QuoteHub) unsubscribe(ch chan FetchResult) {
+	h.mu.Lock()
+	delete(h.subscribers, ch)
+	h.mu.Unlock()
+}
+
+func (h *QuoteHub) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error upgrading stream connection", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+
+	// The read loop only exists to notice the client going away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-r.Context().Done():
+			return
+		case result := <-ch:
+			if err := conn.WriteJSON(CotacaoResponse{Bid: result.Bid, Source: result.Source}); err != nil {
+				slog.WarnContext(r.Context(), "Error writing to stream", "error", err)
+				return
+			}
+		}
+	}
+}
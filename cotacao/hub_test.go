@@ -0,0 +1,62 @@
+package cotacao_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+func TestQuoteHubStreamsToSubscribers(t *testing.T) {
+	fetcher := &cotacaotest.StubCotacaoFetcher{Bid: "5.4321"}
+	hub := cotacao.NewQuoteHub(fetcher, cotacao.DefaultPair, 10*time.Millisecond, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hub.Run(ctx)
+	srv := httptest.NewServer(http.HandlerFunc(hub.StreamHandler))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var conns []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("dialing the stream: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	for i, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for n := 0; n < 2; n++ {
+			var msg cotacao.CotacaoResponse
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("subscriber %d, message %d: %v", i, n, err)
+			}
+			if msg.Bid != "5.4321" {
+				t.Errorf("subscriber %d, message %d: bid %q, want 5.4321", i, n, msg.Bid)
+			}
+		}
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	// Once both subscribers are gone the hub stops calling upstream.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		before := fetcher.Calls()
+		time.Sleep(50 * time.Millisecond)
+		if fetcher.Calls() == before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hub kept fetching after every subscriber disconnected")
+		}
+	}
+}
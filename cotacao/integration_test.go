@@ -0,0 +1,96 @@
+package cotacao_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+// newHarness builds a harness around payload and closes it with the test.
+func newHarness(t *testing.T, payload string, opts ...cotacao.Option) *cotacaotest.Harness {
+	t.Helper()
+	h, err := cotacaotest.NewHarness(payload, opts...)
+	if err != nil {
+		t.Fatalf("NewHarness: %v", err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestCotacaoFetchesAndPersists(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+
+	rec := httptest.NewRecorder()
+	h.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body struct {
+		Bid       string `json:"bid"`
+		Pair      string `json:"pair"`
+		Source    string `json:"source"`
+		Persisted bool   `json:"persisted"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	if body.Bid != "5.4321" || body.Pair != cotacao.DefaultPair || body.Source != cotacao.SourceLive || !body.Persisted {
+		t.Errorf("body = %+v, want bid 5.4321 for %s, live and persisted", body, cotacao.DefaultPair)
+	}
+
+	stored, err := h.Repository.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if stored.Bid != "5.4321" || stored.Pair != cotacao.DefaultPair || stored.Source != cotacao.SourceLive {
+		t.Errorf("stored row = %+v, want bid 5.4321 for %s from %s", stored, cotacao.DefaultPair, cotacao.SourceLive)
+	}
+}
+
+func TestCotacaoMissingPairStoresNoEmptyBid(t *testing.T) {
+	h := newHarness(t, `{"OTHER":{"bid":"1"}}`)
+
+	rec := httptest.NewRecorder()
+	h.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+
+	rows, err := h.Repository.List(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, row := range rows {
+		if row.Bid == "" || row.Source == cotacao.SourceLive {
+			t.Errorf("stored %+v for an upstream answer without the pair", row)
+		}
+	}
+}
+
+func TestReadyReportsDatabaseIndependentlyOfHealth(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+	get := func(target string) int {
+		rec := httptest.NewRecorder()
+		h.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec.Code
+	}
+
+	if code := get("/ready"); code != http.StatusOK {
+		t.Fatalf("/ready with the database open = %d, want %d", code, http.StatusOK)
+	}
+
+	h.DB.Close()
+
+	if code := get("/ready"); code != http.StatusServiceUnavailable {
+		t.Errorf("/ready with the database closed = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if code := get("/health"); code != http.StatusOK {
+		t.Errorf("/health with the database closed = %d, want %d from the closed circuit", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,45 @@
+package cotacao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sync/semaphore"
+)
+
+var ErrTooManyFetches = errors.New("too many concurrent upstream fetches")
+
+// LimitedCotacaoFetcher caps how many Fetch calls run at once on the wrapped
+// fetcher. Calls over the limit wait for a slot until their context is done,
+// or fail at once with ErrTooManyFetches when wait is false.
+type LimitedCotacaoFetcher struct {
+	fetcher CotacaoFetcher
+	sem     *semaphore.Weighted
+	wait    bool
+}
+
+func NewLimitedCotacaoFetcher(fetcher CotacaoFetcher, limit int64, wait bool) *LimitedCotacaoFetcher {
+	return &LimitedCotacaoFetcher{fetcher: fetcher, sem: semaphore.NewWeighted(limit), wait: wait}
+}
+
+func (l *LimitedCotacaoFetcher) Fetch(ctx context.Context, pair string) (FetchResult, error) {
+	if l.wait {
+		if err := l.sem.Acquire(ctx, 1); err != nil {
+			return FetchResult{}, fmt.Errorf("%w: %w", ErrTooManyFetches, err)
+		}
+	} else if !l.sem.TryAcquire(1) {
+		return FetchResult{}, ErrTooManyFetches
+	}
+	defer l.sem.Release(1)
+
+	return l.fetcher.Fetch(ctx, pair)
+}
+
+func (l *LimitedCotacaoFetcher) Probe(ctx context.Context) error {
+	return probeOf(ctx, l.fetcher)
+}
+
+func (l *LimitedCotacaoFetcher) State() CircuitState {
+	return stateOf(l.fetcher)
+}
@@ -0,0 +1,106 @@
+package cotacao_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+func TestLimitedFetcherCapsConcurrency(t *testing.T) {
+	upstream := newGateFetcher()
+	limited := cotacao.NewLimitedCotacaoFetcher(upstream, 3, true)
+
+	const callers = 20
+	var done sync.WaitGroup
+	done.Add(callers)
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer done.Done()
+			_, err := limited.Fetch(context.Background(), cotacao.DefaultPair)
+			errs <- err
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		<-upstream.started
+	}
+	// Give callers over the limit the chance to slip through.
+	time.Sleep(20 * time.Millisecond)
+	if n := upstream.calls.Load(); n != 3 {
+		t.Errorf("%d fetches in flight, want the limit of 3", n)
+	}
+
+	close(upstream.release)
+	done.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("waiting caller: %v", err)
+		}
+	}
+	if n := upstream.calls.Load(); n != callers {
+		t.Errorf("%d fetches reached the upstream, want all %d once slots freed", n, callers)
+	}
+}
+
+func TestLimitedFetcherFastFails(t *testing.T) {
+	upstream := newGateFetcher()
+	limited := cotacao.NewLimitedCotacaoFetcher(upstream, 2, false)
+	var done sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			limited.Fetch(context.Background(), cotacao.DefaultPair)
+		}()
+		<-upstream.started
+	}
+	t.Cleanup(func() {
+		close(upstream.release)
+		done.Wait()
+	})
+
+	if _, err := limited.Fetch(context.Background(), cotacao.DefaultPair); !errors.Is(err, cotacao.ErrTooManyFetches) {
+		t.Errorf("Fetch over the limit: err = %v, want %v", err, cotacao.ErrTooManyFetches)
+	}
+
+	server := cotacao.NewServer(limited, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+	if rec.Code != http.StatusServiceUnavailable || decode(t, rec)["code"] != "TOO_MANY_FETCHES" {
+		t.Errorf("status = %d, body %s; want %d TOO_MANY_FETCHES", rec.Code, rec.Body, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+}
+
+func TestLimitedFetcherWaitHonoursContext(t *testing.T) {
+	upstream := newGateFetcher()
+	limited := cotacao.NewLimitedCotacaoFetcher(upstream, 1, true)
+	var done sync.WaitGroup
+	done.Add(1)
+	go func() {
+		defer done.Done()
+		limited.Fetch(context.Background(), cotacao.DefaultPair)
+	}()
+	<-upstream.started
+	t.Cleanup(func() {
+		close(upstream.release)
+		done.Wait()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := limited.Fetch(ctx, cotacao.DefaultPair)
+
+	if !errors.Is(err, cotacao.ErrTooManyFetches) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v wrapping %v", err, cotacao.ErrTooManyFetches, context.DeadlineExceeded)
+	}
+}
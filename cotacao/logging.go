@@ -0,0 +1,53 @@
+package cotacao
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+type requestIDKey struct{}
+
+type correlationIDKey struct{}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request and correlation IDs found in the context to
+// every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if id := CorrelationIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("correlation_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func NewLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == "json" {
+		return slog.New(contextHandler{slog.NewJSONHandler(w, opts)})
+	}
+	return slog.New(contextHandler{slog.NewTextHandler(w, opts)})
+}
@@ -0,0 +1,61 @@
+package cotacao_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+// captureLogs routes the default logger into a JSON buffer for the rest of
+// the test.
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(cotacao.NewLogger(&buf, "json", level))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// logRecords decodes the JSON lines in buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decoding log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestCircuitOpenLoggedAtWarn(t *testing.T) {
+	logs := captureLogs(t, slog.LevelDebug)
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/", cotacao.WithRetry(0), cotacao.WithFailureThreshold(1))
+
+	fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+	for _, record := range logRecords(t, logs) {
+		if record["msg"] == "Circuit breaker opened" {
+			if record["level"] != "WARN" {
+				t.Errorf("circuit-open logged at %v, want WARN", record["level"])
+			}
+			return
+		}
+	}
+	t.Errorf("no circuit-open record in:\n%s", logs)
+}
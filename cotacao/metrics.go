@@ -0,0 +1,161 @@
+package cotacao
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type FetcherMetrics struct {
+	successes    prometheus.Counter
+	failures     prometheus.Counter
+	fallbacks    prometheus.Counter
+	circuitOpens prometheus.Counter
+	latency      prometheus.Histogram
+}
+
+func NewFetcherMetrics(prefix string, registerer prometheus.Registerer) *FetcherMetrics {
+	m := &FetcherMetrics{
+		successes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "fetch_success_total",
+			Help:      "Number of successful upstream fetches.",
+		}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "fetch_failure_total",
+			Help:      "Number of failed upstream fetch attempts.",
+		}),
+		fallbacks: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "fetch_fallback_total",
+			Help:      "Number of times the fallback value was served.",
+		}),
+		circuitOpens: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "circuit_open_total",
+			Help:      "Number of times the circuit breaker opened.",
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Name:      "fetch_duration_seconds",
+			Help:      "Latency of upstream fetch attempts.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+	registerer.MustRegister(m.successes, m.failures, m.fallbacks, m.circuitOpens, m.latency)
+	return m
+}
+
+func (m *FetcherMetrics) incSuccess() {
+	if m != nil {
+		m.successes.Inc()
+	}
+}
+
+func (m *FetcherMetrics) incFailure() {
+	if m != nil {
+		m.failures.Inc()
+	}
+}
+
+func (m *FetcherMetrics) incFallback() {
+	if m != nil {
+		m.fallbacks.Inc()
+	}
+}
+
+func (m *FetcherMetrics) incCircuitOpen() {
+	if m != nil {
+		m.circuitOpens.Inc()
+	}
+}
+
+func (m *FetcherMetrics) observeLatency(d time.Duration) {
+	if m != nil {
+		m.latency.Observe(d.Seconds())
+	}
+}
+
+// RepositoryMetrics records the latency and failures of repository saves.
+type RepositoryMetrics struct {
+	saveErrors  *prometheus.CounterVec
+	saveLatency prometheus.Histogram
+}
+
+func NewRepositoryMetrics(prefix string, registerer prometheus.Registerer) *RepositoryMetrics {
+	m := &RepositoryMetrics{
+		saveErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: prefix,
+			Name:      "db_save_error_total",
+			Help:      "Number of failed saves, by error type.",
+		}, []string{"type"}),
+		saveLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: prefix,
+			Name:      "db_save_duration_seconds",
+			Help:      "Latency of saves to the database.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+	}
+	registerer.MustRegister(m.saveErrors, m.saveLatency)
+	return m
+}
+
+func (m *RepositoryMetrics) observeSave(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.saveLatency.Observe(d.Seconds())
+	if err != nil {
+		m.saveErrors.WithLabelValues(saveErrorType(err)).Inc()
+	}
+}
+
+// saveErrorType labels err as "timeout", "lock" or "other".
+func saveErrorType(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+		return "lock"
+	}
+	// 55P03 is lock_not_available and 40P01 deadlock_detected.
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == "55P03" || pqErr.Code == "40P01") {
+		return "lock"
+	}
+	return "other"
+}
+
+// MeasuredCotacaoRepository records Save and SaveBatch in RepositoryMetrics
+// and passes every other call straight to the wrapped repository.
+type MeasuredCotacaoRepository struct {
+	CotacaoRepository
+	metrics *RepositoryMetrics
+}
+
+func NewMeasuredCotacaoRepository(repository CotacaoRepository, metrics *RepositoryMetrics) *MeasuredCotacaoRepository {
+	return &MeasuredCotacaoRepository{CotacaoRepository: repository, metrics: metrics}
+}
+
+func (r *MeasuredCotacaoRepository) Save(ctx context.Context, pair, bid, source string, fetchedAt time.Time, latency time.Duration) (int64, error) {
+	start := time.Now()
+	id, err := r.CotacaoRepository.Save(ctx, pair, bid, source, fetchedAt, latency)
+	r.metrics.observeSave(time.Since(start), err)
+	return id, err
+}
+
+func (r *MeasuredCotacaoRepository) SaveBatch(ctx context.Context, items []StoredCotacao) error {
+	start := time.Now()
+	err := r.CotacaoRepository.SaveBatch(ctx, items)
+	r.metrics.observeSave(time.Since(start), err)
+	return err
+}
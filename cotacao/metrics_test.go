@@ -0,0 +1,98 @@
+package cotacao_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrape returns the text exposition of registry as served on /metrics.
+func scrape(t *testing.T, registry *prometheus.Registry) string {
+	t.Helper()
+	srv := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("scraping /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading /metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestFetcherMetricsCountFailures(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := cotacao.NewFetcherMetrics("test", registry)
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithMetrics(metrics),
+		cotacao.WithRetry(2),
+		cotacao.WithFailureThreshold(3),
+	)
+
+	before := scrape(t, registry)
+	fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+	after := scrape(t, registry)
+
+	for _, line := range []string{
+		"test_fetch_failure_total 3",
+		"test_circuit_open_total 1",
+		"test_fetch_fallback_total 1",
+		"test_fetch_success_total 0",
+		"test_fetch_duration_seconds_count 3",
+	} {
+		if !strings.Contains(after, line+"\n") {
+			t.Errorf("/metrics lacks %q after a failed fetch:\n%s", line, after)
+		}
+	}
+	if !strings.Contains(before, "test_fetch_failure_total 0\n") {
+		t.Errorf("/metrics should start with no failures:\n%s", before)
+	}
+}
+
+func TestRepositoryMetricsLabelSaveErrors(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := cotacao.NewRepositoryMetrics("test", registry)
+	sqlite := cotacao.NewMeasuredCotacaoRepository(cotacao.NewSQLiteCotacaoRepository(openSQLite(t)), metrics)
+
+	if _, err := sqlite.Save(context.Background(), cotacao.DefaultPair, "5.43", cotacao.SourceLive, time.Now(), 0); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	if _, err := sqlite.Save(ctx, cotacao.DefaultPair, "5.43", cotacao.SourceLive, time.Now(), 0); err == nil {
+		t.Fatal("Save succeeded past its deadline")
+	}
+	failing := cotacao.NewMeasuredCotacaoRepository(&cotacaotest.StubCotacaoRepository{Err: errors.New("disk full")}, metrics)
+	failing.Save(context.Background(), cotacao.DefaultPair, "5.43", cotacao.SourceLive, time.Now(), 0)
+
+	got := scrape(t, registry)
+	for _, line := range []string{
+		`test_db_save_error_total{type="timeout"} 1`,
+		`test_db_save_error_total{type="other"} 1`,
+		"test_db_save_duration_seconds_count 3",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("/metrics lacks %q:\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, `type="lock"`) {
+		t.Errorf("/metrics counts a lock error for saves that did not hit one:\n%s", got)
+	}
+}
@@ -0,0 +1,520 @@
+package cotacao
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// Middleware wraps a handler with behaviour run around it.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes middlewares so the first one listed runs first: Chain(a, b)(h)
+// is a(b(h)). Nil entries are skipped, which lets callers leave out optional
+// middlewares in place.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			if middlewares[i] != nil {
+				next = middlewares[i](next)
+			}
+		}
+		return next
+	}
+}
+
+type RateLimiter struct {
+	limiter *rate.Limiter
+	now     func() time.Time
+}
+
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	return &RateLimiter{limiter: rate.NewLimiter(rate.Limit(rps), burst), now: time.Now}
+}
+
+// Middleware rejects requests over the shared budget with 429 and a
+// Retry-After header telling the client when a token becomes available.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowRequest(w, l.limiter, l.now()) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowRequest takes a token from limiter, or writes the 429 response and
+// returns false when none is available at now.
+func allowRequest(w http.ResponseWriter, limiter *rate.Limiter, now time.Time) bool {
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		writeJSONError(w, http.StatusTooManyRequests, "RATE_LIMITED", "Too many requests")
+		return false
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		writeJSONError(w, http.StatusTooManyRequests, "RATE_LIMITED", "Too many requests")
+		return false
+	}
+	return true
+}
+
+type ipBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// IPRateLimiter keeps a token bucket per client IP. X-Forwarded-For is only
+// trusted when the request comes from one of the trusted proxies.
+type IPRateLimiter struct {
+	rps     rate.Limit
+	burst   int
+	trusted []netip.Prefix
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[netip.Addr]*ipBucket
+}
+
+// NewIPRateLimiter parses trustedProxies as IPs or CIDR prefixes.
+func NewIPRateLimiter(rps float64, burst int, trustedProxies []string) (*IPRateLimiter, error) {
+	l := &IPRateLimiter{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		now:     time.Now,
+		buckets: make(map[netip.Addr]*ipBucket),
+	}
+	for _, proxy := range trustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		l.trusted = append(l.trusted, prefix.Masked())
+	}
+	return l, nil
+}
+
+func (l *IPRateLimiter) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range l.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the request's RemoteAddr or, when that is a trusted proxy,
+// the rightmost X-Forwarded-For entry that is not itself a trusted proxy.
+func (l *IPRateLimiter) clientIP(r *http.Request) (netip.Addr, bool) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	client := addrPort.Addr().Unmap()
+	if !l.isTrusted(client) {
+		return client, true
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap()
+		if !l.isTrusted(client) {
+			break
+		}
+	}
+	return client, true
+}
+
+func (l *IPRateLimiter) bucket(addr netip.Addr, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &ipBucket{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.buckets[addr] = b
+	}
+	b.lastSeen = now
+	return b.limiter
+}
+
+// Middleware rejects requests over the client's budget like
+// RateLimiter.Middleware does for the shared one.
+func (l *IPRateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := l.clientIP(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := l.now()
+		if !allowRequest(w, l.bucket(addr, now), now) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// evictIdle drops the buckets not used since before cutoff.
+func (l *IPRateLimiter) evictIdle(cutoff time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	evicted := 0
+	for addr, b := range l.buckets {
+		if b.lastSeen.Before(cutoff) {
+			delete(l.buckets, addr)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// RunCleanup evicts buckets idle for longer than idle every interval until
+// ctx is done.
+func (l *IPRateLimiter) RunCleanup(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if evicted := l.evictIdle(l.now().Add(-idle)); evicted > 0 {
+			slog.DebugContext(ctx, "Evicted idle rate limit buckets", "evicted", evicted)
+		}
+	}
+}
+
+type CORS struct {
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewCORS(origins []string) *CORS {
+	c := &CORS{allowedOrigins: make(map[string]bool)}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.allowAll = true
+		}
+		c.allowedOrigins[origin] = true
+	}
+	return c
+}
+
+func (c *CORS) allowed(origin string) bool {
+	return c.allowAll || c.allowedOrigins[origin]
+}
+
+// Middleware answers preflight requests and sets the CORS headers for allowed
+// origins. Requests from other origins are rejected with 403; requests without
+// an Origin header are not cross-origin and pass through untouched.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			writeJSONError(w, http.StatusForbidden, "ORIGIN_NOT_ALLOWED", "Origin not allowed")
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Request-ID, X-Correlation-ID")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type Compressor struct {
+	minSize int
+}
+
+// NewCompressor returns a gzip middleware that leaves responses smaller than
+// minSize bytes uncompressed.
+func NewCompressor(minSize int) *Compressor {
+	return &Compressor{minSize: minSize}
+}
+
+// Middleware gzips responses for clients that accept it.
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: c.minSize, status: http.StatusOK}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the body until it reaches minSize, then switches
+// to gzip. Smaller bodies are written as is when the handler returns.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize     int
+	status      int
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+	passthrough bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(p)
+	case w.passthrough:
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf.Write(p)
+	if w.buf.Len() < w.minSize {
+		return len(p), nil
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buf.Bytes())
+		return len(p), err
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	// The compressed bytes differ from the identity ones, so a strong ETag
+	// no longer identifies them and is sent as weak.
+	if etag := w.Header().Get("ETag"); strings.HasPrefix(etag, `"`) {
+		w.Header().Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf.Bytes())
+	return len(p), err
+}
+
+func (w *gzipResponseWriter) finish() {
+	switch {
+	case w.gz != nil:
+		w.gz.Close()
+	case !w.passthrough:
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf.Bytes())
+	}
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Timeout caps how long a request may take, answering 504 with a JSON error
+// when it runs out. Unlike http.TimeoutHandler it keeps the API's error shape.
+type Timeout struct {
+	duration time.Duration
+	exempt   map[string]bool
+}
+
+// NewTimeout returns a middleware limiting requests to d. Requests for the
+// exempt paths, such as long-lived streams, pass through without a limit.
+func NewTimeout(d time.Duration, exempt ...string) *Timeout {
+	t := &Timeout{duration: d, exempt: make(map[string]bool, len(exempt))}
+	for _, path := range exempt {
+		t.exempt[path] = true
+	}
+	return t
+}
+
+// Middleware runs next with a deadline on the request context and buffers
+// its response, which is only sent if next returns in time. Handlers left
+// running after the deadline write into the discarded buffer.
+func (t *Timeout) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if t.exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), t.duration)
+		defer cancel()
+
+		tw := &timeoutWriter{header: make(http.Header), status: http.StatusOK}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			slog.WarnContext(r.Context(), "Request timed out", "path", r.URL.Path, "timeout", t.duration)
+			writeJSONError(w, http.StatusGatewayTimeout, "REQUEST_TIMEOUT", fmt.Sprintf("Request did not complete within %s", t.duration))
+		}
+	})
+}
+
+// timeoutWriter collects a response for Timeout, refusing writes once the
+// request has timed out.
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	status      int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+	w.status, w.wroteHeader = status, true
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.wroteHeader = true
+	return w.buf.Write(p)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack lets the WebSocket upgrader take over the connection through the
+// middleware.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// WithRequestID reuses the inbound X-Request-ID or generates one, echoes it
+// in the response and stores it in the request context for logging.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// WithCorrelationID echoes the client's X-Correlation-ID and stores it in the
+// request context for logging. Requests without one pass through untouched.
+func WithCorrelationID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationIDHeader)
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(CorrelationIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), correlationIDKey{}, id)))
+	})
+}
+
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		slog.InfoContext(r.Context(), "Request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"latency", time.Since(start),
+		)
+	})
+}
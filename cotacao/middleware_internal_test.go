@@ -0,0 +1,115 @@
+package cotacao
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstThen429(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(0.5, 2)
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := request(); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: status %d, want %d", i+1, rec.Code, http.StatusOK)
+		}
+	}
+
+	rec := request()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	// At 0.5 requests per second the next token is 2s away.
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+
+	now = now.Add(2 * time.Second)
+	if rec := request(); rec.Code != http.StatusOK {
+		t.Errorf("request after the refill: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := request(); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second request after one refill: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestIPRateLimiterIndependentBudgets(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter, err := NewIPRateLimiter(1, 1, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatalf("NewIPRateLimiter: %v", err)
+	}
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         int
+	}{
+		{"first client through the proxy", "10.0.0.1:1234", "203.0.113.1", http.StatusOK},
+		{"first client again", "10.0.0.2:1234", "203.0.113.1", http.StatusTooManyRequests},
+		{"second client through the proxy", "10.0.0.1:1234", "203.0.113.2", http.StatusOK},
+		{"spoofed header from an untrusted peer", "198.51.100.7:1234", "203.0.113.3", http.StatusOK},
+		{"untrusted peer again, spoofing another client", "198.51.100.7:1234", "203.0.113.4", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if got := request(tt.remoteAddr, tt.forwardedFor); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIPRateLimiterEvictsIdleBuckets(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter, err := NewIPRateLimiter(1, 1, nil)
+	if err != nil {
+		t.Fatalf("NewIPRateLimiter: %v", err)
+	}
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(remoteAddr string) {
+		req := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+		req.RemoteAddr = remoteAddr
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	request("203.0.113.1:1234")
+	now = now.Add(time.Minute)
+	request("203.0.113.2:1234")
+
+	if evicted := limiter.evictIdle(now.Add(-30 * time.Second)); evicted != 1 {
+		t.Errorf("evicted %d buckets, want only the one idle for a minute", evicted)
+	}
+	limiter.mu.Lock()
+	_, staleKept := limiter.buckets[netip.MustParseAddr("203.0.113.1")]
+	_, freshKept := limiter.buckets[netip.MustParseAddr("203.0.113.2")]
+	limiter.mu.Unlock()
+	if staleKept || !freshKept {
+		t.Errorf("after eviction: stale bucket kept %v, fresh bucket kept %v; want only the fresh one", staleKept, freshKept)
+	}
+}
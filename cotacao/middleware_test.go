@@ -0,0 +1,253 @@
+package cotacao_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	record := func(name string) cotacao.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" before")
+				next.ServeHTTP(w, r)
+				calls = append(calls, name+" after")
+			})
+		}
+	}
+	handler := cotacao.Chain(record("first"), nil, record("second"), record("third"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+
+	want := []string{"first before", "second before", "third before", "handler", "third after", "second after", "first after"}
+	if !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	var seen string
+	handler := cotacao.WithRequestID(cotacao.LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = cotacao.RequestIDFromContext(r.Context())
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("X-Request-ID"); got != "req-123" {
+		t.Errorf("X-Request-ID = %q, want the inbound req-123", got)
+	}
+	if seen != "req-123" {
+		t.Errorf("request ID in context = %q, want req-123", seen)
+	}
+	records := logRecords(t, logs)
+	if len(records) != 1 || records[0]["request_id"] != "req-123" {
+		t.Errorf("log records = %v, want one carrying request_id req-123", records)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+	if got := rec.Header().Get("X-Request-ID"); got == "" || got != seen {
+		t.Errorf("generated X-Request-ID = %q, want it non-empty and matching the context's %q", got, seen)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	called := false
+	handler := cotacao.NewCORS([]string{"https://dash.example"}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		called = false
+		req := httptest.NewRequest(method, "/cotacao", nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		rec := request(http.MethodOptions, "https://dash.example")
+		if rec.Code != http.StatusNoContent || called {
+			t.Errorf("status = %d, handler called %v; want %d answered by the middleware", rec.Code, called, http.StatusNoContent)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, OPTIONS" {
+			t.Errorf("Access-Control-Allow-Methods = %q", got)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Headers"); got == "" {
+			t.Error("Access-Control-Allow-Headers is missing")
+		}
+	})
+	t.Run("allowed origin", func(t *testing.T) {
+		rec := request(http.MethodGet, "https://dash.example")
+		if !called || rec.Header().Get("Access-Control-Allow-Origin") != "https://dash.example" {
+			t.Errorf("handler called %v with Access-Control-Allow-Origin %q, want the origin echoed", called, rec.Header().Get("Access-Control-Allow-Origin"))
+		}
+	})
+	t.Run("disallowed origin", func(t *testing.T) {
+		rec := request(http.MethodGet, "https://evil.example")
+		if rec.Code != http.StatusForbidden || called {
+			t.Errorf("status = %d, handler called %v; want %d without reaching it", rec.Code, called, http.StatusForbidden)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+			t.Errorf("Access-Control-Allow-Origin = %q for a disallowed origin", got)
+		}
+	})
+}
+
+func TestCompressor(t *testing.T) {
+	large := strings.Repeat(`{"bid":"5.4321"},`, 100)
+	handler := cotacao.NewCompressor(1024).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("size") == "large" {
+			io.WriteString(w, large)
+			return
+		}
+		io.WriteString(w, `{"bid":"5.4321"}`)
+	}))
+	request := func(target, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		target         string
+		acceptEncoding string
+		wantGzip       bool
+		wantBody       string
+	}{
+		{"large", "/cotacao/history?size=large", "gzip, deflate", true, large},
+		{"small", "/cotacao/history", "gzip", false, `{"bid":"5.4321"}`},
+		{"gzip refused", "/cotacao/history?size=large", "gzip;q=0, identity", false, large},
+		{"no Accept-Encoding", "/cotacao/history?size=large", "", false, large},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(tt.target, tt.acceptEncoding)
+
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+			body := rec.Body.String()
+			if encoding := rec.Header().Get("Content-Encoding"); (encoding == "gzip") != tt.wantGzip {
+				t.Fatalf("Content-Encoding = %q, want gzip %v", encoding, tt.wantGzip)
+			}
+			if tt.wantGzip {
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader: %v", err)
+				}
+				decoded, err := io.ReadAll(gz)
+				if err != nil {
+					t.Fatalf("decompressing: %v", err)
+				}
+				if len(body) >= len(decoded) {
+					t.Errorf("compressed body is %d bytes, want fewer than the %d uncompressed", len(body), len(decoded))
+				}
+				body = string(decoded)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %.40q, want %.40q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWithCorrelationID(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	var seen string
+	handler := cotacao.WithCorrelationID(cotacao.LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = cotacao.CorrelationIDFromContext(r.Context())
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+	req.Header.Set(cotacao.CorrelationIDHeader, "corr-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(cotacao.CorrelationIDHeader); got != "corr-123" {
+		t.Errorf("%s = %q, want the inbound corr-123 echoed", cotacao.CorrelationIDHeader, got)
+	}
+	if seen != "corr-123" {
+		t.Errorf("correlation ID in context = %q, want corr-123", seen)
+	}
+	records := logRecords(t, logs)
+	if len(records) != 1 || records[0]["correlation_id"] != "corr-123" {
+		t.Errorf("log records = %v, want one carrying correlation_id corr-123", records)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+	if got := rec.Header().Get(cotacao.CorrelationIDHeader); got != "" || seen != "" {
+		t.Errorf("without an inbound ID: echoed %q, context %q; want neither set", got, seen)
+	}
+}
+
+func TestTimeoutReturnsJSON504(t *testing.T) {
+	slow := newGateFetcher()
+	defer close(slow.release)
+	config := cotacao.DefaultServerConfig()
+	config.FetchTimeout = time.Minute
+	server := cotacao.NewServer(slow, &cotacaotest.StubCotacaoRepository{}, config)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cotacao", server.CotacaoHandler)
+	mux.HandleFunc("/cotacao/stream", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, "streamed")
+	})
+	mux.HandleFunc("/health", server.HealthHandler)
+	handler := cotacao.NewTimeout(20*time.Millisecond, "/cotacao/stream").Middleware(mux)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("timed-out request took %s, want about 20ms", elapsed)
+	}
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusGatewayTimeout)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body cotacao.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "REQUEST_TIMEOUT" {
+		t.Errorf("body = %s, %v; want the REQUEST_TIMEOUT error", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("fast /health status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cotacao/stream", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "streamed" {
+		t.Errorf("exempt path = %d %q, want 200 streamed past the timeout", rec.Code, rec.Body)
+	}
+}
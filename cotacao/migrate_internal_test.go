@@ -0,0 +1,54 @@
+package cotacao
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestMigrateIsIdempotentAndKeepsRows(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", "file:"+t.Name()+"?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// A database from before the migrations: the original table and a row.
+	if _, err := db.ExecContext(ctx, `CREATE TABLE cotacao (id INTEGER PRIMARY KEY AUTOINCREMENT, bid TEXT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("creating the legacy table: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, `INSERT INTO cotacao(bid) VALUES('5.1234')`); err != nil {
+		t.Fatalf("inserting the legacy row: %v", err)
+	}
+
+	want := sqliteMigrations[len(sqliteMigrations)-1].version
+	for run := 1; run <= 2; run++ {
+		if err := migrate(ctx, db, sqliteMigrations, func(int) string { return "?" }); err != nil {
+			t.Fatalf("run %d: migrate: %v", run, err)
+		}
+		version, err := schemaVersion(ctx, db)
+		if err != nil {
+			t.Fatalf("run %d: schemaVersion: %v", run, err)
+		}
+		if version != want {
+			t.Errorf("run %d: schema version %d, want %d", run, version, want)
+		}
+		var applied int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+			t.Fatalf("run %d: counting schema_migrations: %v", run, err)
+		}
+		if applied != len(sqliteMigrations) {
+			t.Errorf("run %d: %d migrations recorded, want each of the %d once", run, applied, len(sqliteMigrations))
+		}
+	}
+
+	var c StoredCotacao
+	err = db.QueryRowContext(ctx, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao").
+		Scan(&c.ID, &c.Pair, &c.Bid, &c.Source, &c.Timestamp, &c.LatencyMs)
+	if err != nil {
+		t.Fatalf("reading the legacy row: %v", err)
+	}
+	if c.Bid != "5.1234" || c.Pair != DefaultPair || c.Source != SourceLive || c.LatencyMs != 0 {
+		t.Errorf("legacy row after migrating = %+v, want bid 5.1234 with the column defaults", c)
+	}
+}
@@ -0,0 +1,90 @@
+package cotacao
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+type Strategy int
+
+const (
+	FirstSuccess Strategy = iota
+	Median
+	Average
+)
+
+type MultiSourceFetcher struct {
+	sources       []CotacaoFetcher
+	strategy      Strategy
+	fallbackValue string
+}
+
+func NewMultiSourceFetcher(sources []CotacaoFetcher, strategy Strategy, fallbackValue string) CotacaoFetcher {
+	return &MultiSourceFetcher{sources: sources, strategy: strategy, fallbackValue: fallbackValue}
+}
+
+type sourceResult struct {
+	result FetchResult
+	value  decimal.Decimal
+	err    error
+}
+
+// Fetch queries every source concurrently under ctx and combines the
+// successful bids according to the strategy. Fallback values reported by a
+// source are left out of the combination.
+func (m *MultiSourceFetcher) Fetch(ctx context.Context, pair string) (FetchResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan sourceResult, len(m.sources))
+	for _, source := range m.sources {
+		go func(source CotacaoFetcher) {
+			result, err := source.Fetch(ctx, pair)
+			var value decimal.Decimal
+			if err == nil {
+				value, err = parseBid(result.Bid)
+			}
+			results <- sourceResult{result: result, value: value, err: err}
+		}(source)
+	}
+
+	var values []decimal.Decimal
+	var errs []error
+	for range m.sources {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		if r.result.Source == SourceFallback {
+			continue
+		}
+		if m.strategy == FirstSuccess {
+			return r.result, nil
+		}
+		values = append(values, r.value)
+	}
+
+	if len(values) == 0 {
+		slog.ErrorContext(ctx, "All sources failed, using fallback value", "pair", pair, "sources", len(m.sources), "error", errors.Join(errs...))
+		return FetchResult{Bid: m.fallbackValue, Source: SourceFallback}, nil
+	}
+
+	var combined decimal.Decimal
+	switch m.strategy {
+	case Median:
+		sort.Slice(values, func(i, j int) bool { return values[i].LessThan(values[j]) })
+		mid := len(values) / 2
+		combined = values[mid]
+		if len(values)%2 == 0 {
+			combined = decimal.Avg(values[mid-1], values[mid])
+		}
+	case Average:
+		combined = decimal.Avg(values[0], values[1:]...)
+	}
+	return FetchResult{Bid: combined.String(), Source: SourceLive}, nil
+}
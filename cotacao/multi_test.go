@@ -0,0 +1,48 @@
+package cotacao_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+func TestMultiSourceFetcher(t *testing.T) {
+	failing := func() cotacao.CotacaoFetcher { return &cotacaotest.StubCotacaoFetcher{Err: errors.New("down")} }
+	bid := func(b string) cotacao.CotacaoFetcher { return &cotacaotest.StubCotacaoFetcher{Bid: b} }
+	fallback := func(b string) cotacao.CotacaoFetcher {
+		return &cotacaotest.StubCotacaoFetcher{Bid: b, Source: cotacao.SourceFallback}
+	}
+
+	tests := []struct {
+		name     string
+		strategy cotacao.Strategy
+		sources  []cotacao.CotacaoFetcher
+		want     string
+		source   string
+	}{
+		{"first success skips failures", cotacao.FirstSuccess, []cotacao.CotacaoFetcher{failing(), bid("5.10"), failing()}, "5.10", cotacao.SourceLive},
+		{"first success skips fallbacks", cotacao.FirstSuccess, []cotacao.CotacaoFetcher{fallback("1.00"), bid("5.10")}, "5.10", cotacao.SourceLive},
+		{"median of odd", cotacao.Median, []cotacao.CotacaoFetcher{bid("5.30"), failing(), bid("5.10"), bid("5.20")}, "5.2", cotacao.SourceLive},
+		{"median of even", cotacao.Median, []cotacao.CotacaoFetcher{bid("5.10"), bid("5.40"), failing()}, "5.25", cotacao.SourceLive},
+		{"average", cotacao.Average, []cotacao.CotacaoFetcher{bid("5.10"), failing(), bid("5.20"), bid("5.60")}, "5.3", cotacao.SourceLive},
+		{"average ignores fallbacks", cotacao.Average, []cotacao.CotacaoFetcher{bid("5.10"), fallback("1.00"), bid("5.30")}, "5.2", cotacao.SourceLive},
+		{"all failing", cotacao.Median, []cotacao.CotacaoFetcher{failing(), failing()}, "1.00", cotacao.SourceFallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := cotacao.NewMultiSourceFetcher(tt.sources, tt.strategy, "1.00")
+
+			result, err := fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+
+			if result.Bid != tt.want || result.Source != tt.source {
+				t.Errorf("result = %+v, %v; want bid %s from %s", result, err, tt.want, tt.source)
+			}
+			if err != nil {
+				t.Errorf("err = %v, want nil", err)
+			}
+		})
+	}
+}
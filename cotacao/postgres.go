@@ -0,0 +1,130 @@
+package cotacao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type PostgresCotacaoRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresCotacaoRepository(db *sql.DB) CotacaoRepository {
+	return &PostgresCotacaoRepository{db: db}
+}
+
+func (r *PostgresCotacaoRepository) Save(ctx context.Context, pair, bid, source string, fetchedAt time.Time, latency time.Duration) (id int64, err error) {
+	ctx, span := tracer.Start(ctx, "PostgresCotacaoRepository.Save", trace.WithAttributes(attribute.String("cotacao.pair", pair), attribute.String("cotacao.source", source)))
+	defer func() { endSpan(span, err) }()
+
+	err = r.db.QueryRowContext(ctx, "INSERT INTO cotacao(pair, bid, source, timestamp, latency_ms) VALUES($1, $2, $3, $4, $5) RETURNING id", pair, bid, source, fetchTimestamp(fetchedAt), latency.Milliseconds()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrSaveFailed, err)
+	}
+	span.SetAttributes(attribute.Int64("db.row_id", id))
+	return id, nil
+}
+
+func (r *PostgresCotacaoRepository) List(ctx context.Context, limit int) ([]StoredCotacao, error) {
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao ORDER BY id DESC LIMIT $1", limit)
+}
+
+func (r *PostgresCotacaoRepository) ListAfter(ctx context.Context, afterID int64, limit int) ([]StoredCotacao, error) {
+	if afterID <= 0 {
+		return r.List(ctx, limit)
+	}
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE id < $1 ORDER BY id DESC LIMIT $2", afterID, limit)
+}
+
+func (r *PostgresCotacaoRepository) Latest(ctx context.Context) (StoredCotacao, error) {
+	var c StoredCotacao
+	err := r.db.QueryRowContext(ctx, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao ORDER BY id DESC LIMIT 1").
+		Scan(&c.ID, &c.Pair, &c.Bid, &c.Source, &c.Timestamp, &c.LatencyMs)
+	return c, err
+}
+
+func (r *PostgresCotacaoRepository) LatestByPair(ctx context.Context, pair string) (StoredCotacao, error) {
+	var c StoredCotacao
+	err := r.db.QueryRowContext(ctx, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE pair = $1 ORDER BY id DESC LIMIT 1", pair).
+		Scan(&c.ID, &c.Pair, &c.Bid, &c.Source, &c.Timestamp, &c.LatencyMs)
+	return c, err
+}
+
+func (r *PostgresCotacaoRepository) Range(ctx context.Context, from, to time.Time, limit int) ([]StoredCotacao, error) {
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE timestamp BETWEEN $1 AND $2 ORDER BY timestamp, id LIMIT $3", from.UTC(), to.UTC(), limit)
+}
+
+func (r *PostgresCotacaoRepository) SaveBatch(ctx context.Context, items []StoredCotacao) error {
+	return saveBatch(ctx, r.db, items, func(n int) string { return "$" + strconv.Itoa(n) })
+}
+
+func (r *PostgresCotacaoRepository) Prune(ctx context.Context, olderThan time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM cotacao WHERE timestamp < $1", olderThan.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *PostgresCotacaoRepository) Average(ctx context.Context, n int) (float64, error) {
+	var avg sql.NullFloat64
+	err := r.db.QueryRowContext(ctx, "SELECT AVG(CAST(bid AS DOUBLE PRECISION)) FROM (SELECT bid FROM cotacao ORDER BY id DESC LIMIT $1) AS recent", n).Scan(&avg)
+	if err != nil {
+		return 0, err
+	}
+	if !avg.Valid {
+		return 0, sql.ErrNoRows
+	}
+	return avg.Float64, nil
+}
+
+func (r *PostgresCotacaoRepository) Page(ctx context.Context, afterID int64, limit int) ([]StoredCotacao, error) {
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
+}
+
+func (r *PostgresCotacaoRepository) Stats(ctx context.Context, since time.Time) (Stats, error) {
+	return queryStats(ctx, r.db, "SELECT COUNT(*), MIN(CAST(bid AS DOUBLE PRECISION)), MAX(CAST(bid AS DOUBLE PRECISION)), AVG(CAST(bid AS DOUBLE PRECISION)) FROM cotacao WHERE timestamp >= $1", since.UTC())
+}
+
+func (r *PostgresCotacaoRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+var postgresMigrations = []migration{
+	{1, "create cotacao", execMigration(`CREATE TABLE IF NOT EXISTS cotacao (id SERIAL PRIMARY KEY, bid TEXT, timestamp TIMESTAMPTZ DEFAULT NOW())`)},
+	{2, "add pair", execMigration(`ALTER TABLE cotacao ADD COLUMN IF NOT EXISTS pair TEXT NOT NULL DEFAULT 'USD-BRL'`)},
+	{3, "add source", execMigration(`ALTER TABLE cotacao ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'live'`)},
+	{4, "index pair", execMigration(`CREATE INDEX IF NOT EXISTS cotacao_pair_id ON cotacao (pair, id)`)},
+	{5, "add latency", execMigration(`ALTER TABLE cotacao ADD COLUMN IF NOT EXISTS latency_ms BIGINT NOT NULL DEFAULT 0`)},
+}
+
+// OpenPostgres connects to the PostgreSQL database at dsn with the given pool
+// settings and migrates the schema.
+func OpenPostgres(dsn string, pool PoolConfig) (*sql.DB, error) {
+	return OpenPostgresDriver("postgres", dsn, pool)
+}
+
+// OpenPostgresDriver is OpenPostgres through the database/sql driver
+// registered as driver, such as a wrapper adding instrumentation.
+func OpenPostgresDriver(driver, dsn string, pool PoolConfig) (*sql.DB, error) {
+	db, err := openDB(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	pool.apply(db)
+
+	placeholder := func(n int) string { return "$" + strconv.Itoa(n) }
+	if err := migrate(context.Background(), db, postgresMigrations, placeholder); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,64 @@
+//go:build integration
+
+package cotacao_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+// Run with a reachable PostgreSQL:
+//
+//	DATABASE_URL=postgres://localhost/cotacao_test?sslmode=disable go test -tags integration ./cotacao
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	db, err := cotacao.OpenPostgres(dsn, cotacao.PoolConfig{})
+	if err != nil {
+		t.Fatalf("OpenPostgres: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// A pair of its own keeps the test's rows apart from any already stored.
+	const pair = "TST-BRL"
+	ctx := context.Background()
+	t.Cleanup(func() { db.ExecContext(ctx, "DELETE FROM cotacao WHERE pair = $1", pair) })
+	repository := cotacao.NewPostgresCotacaoRepository(db)
+
+	fetchedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	id, err := repository.Save(ctx, pair, "5.4321", cotacao.SourceLive, fetchedAt, 12*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	stored, err := repository.LatestByPair(ctx, pair)
+	if err != nil {
+		t.Fatalf("LatestByPair: %v", err)
+	}
+	if stored.ID != id || stored.Bid != "5.4321" || stored.Source != cotacao.SourceLive || stored.LatencyMs != 12 {
+		t.Errorf("stored = %+v, want id %d with bid 5.4321, live, 12ms", stored, id)
+	}
+	if !stored.Timestamp.Equal(fetchedAt) {
+		t.Errorf("timestamp = %s, want %s", stored.Timestamp, fetchedAt)
+	}
+
+	batch := []cotacao.StoredCotacao{
+		{Pair: pair, Bid: "5.1", Source: cotacao.SourceLive, Timestamp: fetchedAt.Add(time.Second)},
+		{Pair: pair, Bid: "5.2", Source: cotacao.SourceLive, Timestamp: fetchedAt.Add(2 * time.Second)},
+	}
+	if err := repository.SaveBatch(ctx, batch); err != nil {
+		t.Fatalf("SaveBatch: %v", err)
+	}
+	if stored, err := repository.LatestByPair(ctx, pair); err != nil || stored.Bid != "5.2" {
+		t.Errorf("LatestByPair after SaveBatch = %+v, %v; want bid 5.2", stored, err)
+	}
+	if err := repository.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
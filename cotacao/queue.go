@@ -0,0 +1,187 @@
+package cotacao
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrQueueFull = errors.New("write queue is full")
+
+// queueFlushTimeout bounds each SaveBatch issued by the write queue.
+const queueFlushTimeout = 5 * time.Second
+
+// Delays between attempts to reach the database after a failed flush.
+const (
+	queueRetryBaseDelay = 100 * time.Millisecond
+	queueRetryMaxDelay  = 5 * time.Second
+)
+
+// WriteQueue persists quotes asynchronously: Enqueue returns at once and a
+// single writer goroutine saves the queued quotes in batches every flush
+// interval, or as soon as a full batch is waiting. While the database is
+// failing the writer keeps the batch and retries with backoff, so the buffer
+// fills and Enqueue starts refusing quotes instead of dropping them. A batch
+// that still fails once the database answers pings is saved one quote at a
+// time, and the quotes the database rejects are dropped and counted, so they
+// cannot hold back the ones queued after them.
+type WriteQueue struct {
+	repository    CotacaoRepository
+	items         chan StoredCotacao
+	flushInterval time.Duration
+	stop          chan struct{}
+	done          chan struct{}
+	stopOnce      sync.Once
+	failing       atomic.Bool
+	dropped       atomic.Int64
+}
+
+func NewWriteQueue(repository CotacaoRepository, size int, flushInterval time.Duration) *WriteQueue {
+	return &WriteQueue{
+		repository:    repository,
+		items:         make(chan StoredCotacao, size),
+		flushInterval: flushInterval,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Enqueue queues item without blocking, failing with ErrQueueFull when the
+// buffer is full.
+func (q *WriteQueue) Enqueue(item StoredCotacao) error {
+	select {
+	case q.items <- item:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Run writes queued quotes until Close is called, then drains and flushes
+// what is left.
+func (q *WriteQueue) Run() {
+	defer close(q.done)
+
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]StoredCotacao, 0, batchChunkSize)
+	for {
+		select {
+		case item := <-q.items:
+			batch = append(batch, item)
+			if len(batch) >= batchChunkSize {
+				batch = q.flush(batch)
+			}
+		case <-ticker.C:
+			batch = q.flush(batch)
+		case <-q.stop:
+			for {
+				select {
+				case item := <-q.items:
+					batch = append(batch, item)
+				default:
+					q.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush saves batch, retrying until it is stored or Close is called. Between
+// attempts it waits with exponential backoff and pings the database, which
+// lets database/sql replace broken connections, before saving again. When the
+// retry after a successful ping fails as well, the fault lies in the quotes
+// rather than the connection, and flush falls back to saveEach.
+func (q *WriteQueue) flush(batch []StoredCotacao) []StoredCotacao {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	count := len(batch)
+	delay := queueRetryBaseDelay
+	reachable := false
+	for {
+		err := q.save(batch)
+		if err != nil && reachable {
+			batch, err = q.saveEach(batch)
+		}
+		if err == nil {
+			if q.failing.Swap(false) {
+				slog.Info("Write queue recovered", "count", count)
+			}
+			return batch[:0]
+		}
+		q.failing.Store(true)
+
+		for err != nil {
+			slog.Error("Error saving queued cotacoes, retrying", "count", len(batch), "retry_in", delay, "error", err)
+			select {
+			case <-q.stop:
+				slog.Error("Dropping queued cotacoes on shutdown", "count", len(batch), "error", err)
+				return batch[:0]
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, queueRetryMaxDelay)
+			err = q.ping()
+		}
+		reachable = true
+	}
+}
+
+// saveEach saves batch one quote at a time, dropping the quotes the database
+// rejects while it still answers pings. When a ping fails too, it stops and
+// returns the unsaved rest of batch with the ping error.
+func (q *WriteQueue) saveEach(batch []StoredCotacao) ([]StoredCotacao, error) {
+	for i, item := range batch {
+		err := q.save(batch[i : i+1])
+		if err == nil {
+			continue
+		}
+		if pingErr := q.ping(); pingErr != nil {
+			return batch[i:], pingErr
+		}
+		q.dropped.Add(1)
+		slog.Error("Dropping queued cotacao the database rejects", "pair", item.Pair, "bid", item.Bid, "error", err)
+	}
+	return batch[:0], nil
+}
+
+func (q *WriteQueue) save(batch []StoredCotacao) error {
+	ctx, cancel := context.WithTimeout(context.Background(), queueFlushTimeout)
+	defer cancel()
+	return q.repository.SaveBatch(ctx, batch)
+}
+
+func (q *WriteQueue) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), queueFlushTimeout)
+	defer cancel()
+	return q.repository.Ping(ctx)
+}
+
+// Dropped returns how many queued quotes were dropped because the database
+// rejected them.
+func (q *WriteQueue) Dropped() int64 {
+	return q.dropped.Load()
+}
+
+// Healthy reports whether the last flush reached the database.
+func (q *WriteQueue) Healthy() bool {
+	return !q.failing.Load()
+}
+
+// Close stops the writer and waits until the queue is flushed or ctx is done.
+// Run must have been started.
+func (q *WriteQueue) Close(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.stop) })
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,202 @@
+package cotacao_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+func TestWriteQueuePersistsEnqueuedQuotes(t *testing.T) {
+	repository := &cotacaotest.StubCotacaoRepository{}
+	queue := cotacao.NewWriteQueue(repository, 100, 5*time.Millisecond)
+	go queue.Run()
+	t.Cleanup(func() { queue.Close(context.Background()) })
+	config := cotacao.DefaultServerConfig()
+	config.WriteQueue = queue
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, repository, config)
+
+	const n = 30
+	for i := 0; i < n; i++ {
+		rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+		if rec.Code != http.StatusOK || decode(t, rec)["queued"] != true {
+			t.Fatalf("request %d: status = %d, body %s; want %d and queued", i+1, rec.Code, rec.Body, http.StatusOK)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(repository.Rows()) < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rows := repository.Rows(); len(rows) != n {
+		t.Errorf("persisted %d rows, want all %d enqueued", len(rows), n)
+	}
+	if n := repository.Calls("Save"); n != 0 {
+		t.Errorf("Save called %d times, want the queue to write through SaveBatch only", n)
+	}
+}
+
+func TestWriteQueueCloseFlushes(t *testing.T) {
+	repository := &cotacaotest.StubCotacaoRepository{}
+	// The flush interval never elapses, so only Close writes the quotes.
+	queue := cotacao.NewWriteQueue(repository, 100, time.Hour)
+	go queue.Run()
+
+	for i := 0; i < 10; i++ {
+		item := cotacao.StoredCotacao{Pair: cotacao.DefaultPair, Bid: fmt.Sprintf("5.%04d", i), Source: cotacao.SourceLive, Timestamp: time.Now()}
+		if err := queue.Enqueue(item); err != nil {
+			t.Fatalf("Enqueue %d: %v", i, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if rows := repository.Rows(); len(rows) != 10 {
+		t.Errorf("persisted %d rows after Close, want the 10 queued", len(rows))
+	}
+}
+
+// flakyRepository fails SaveBatch and Ping while down is set.
+type flakyRepository struct {
+	*cotacaotest.StubCotacaoRepository
+	down atomic.Bool
+}
+
+func (r *flakyRepository) SaveBatch(ctx context.Context, items []cotacao.StoredCotacao) error {
+	if r.down.Load() {
+		return errors.New("database is down")
+	}
+	return r.StubCotacaoRepository.SaveBatch(ctx, items)
+}
+
+func (r *flakyRepository) Ping(ctx context.Context) error {
+	if r.down.Load() {
+		return errors.New("database is down")
+	}
+	return r.StubCotacaoRepository.Ping(ctx)
+}
+
+func TestWriteQueueRecoversFromDatabaseFailure(t *testing.T) {
+	repository := &flakyRepository{StubCotacaoRepository: &cotacaotest.StubCotacaoRepository{}}
+	repository.down.Store(true)
+	queue := cotacao.NewWriteQueue(repository, 10, 5*time.Millisecond)
+	go queue.Run()
+	t.Cleanup(func() { queue.Close(context.Background()) })
+	config := cotacao.DefaultServerConfig()
+	config.WriteQueue = queue
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, repository, config)
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	waitFor("the failed flush", func() bool { return !queue.Healthy() })
+	if rec := serve(t, server.ReadyHandler, http.MethodGet, "/ready"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready while the database is down = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	repository.down.Store(false)
+	waitFor("the retried flush", queue.Healthy)
+
+	if rows := repository.Rows(); len(rows) != 1 || rows[0].Bid != "5.4300" {
+		t.Errorf("stored %+v after recovering, want the queued 5.4300 kept and saved", rows)
+	}
+	if rec := serve(t, server.ReadyHandler, http.MethodGet, "/ready"); rec.Code != http.StatusOK {
+		t.Errorf("/ready after recovering = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+// rejectingRepository fails every SaveBatch holding a quote with the bid
+// "invalid", as a constraint violation would, while Ping keeps succeeding.
+type rejectingRepository struct {
+	*cotacaotest.StubCotacaoRepository
+}
+
+func (r *rejectingRepository) SaveBatch(ctx context.Context, items []cotacao.StoredCotacao) error {
+	for _, item := range items {
+		if item.Bid == "invalid" {
+			return errors.New("CHECK constraint failed: bid")
+		}
+	}
+	return r.StubCotacaoRepository.SaveBatch(ctx, items)
+}
+
+func TestWriteQueueDropsRejectedQuotes(t *testing.T) {
+	repository := &rejectingRepository{StubCotacaoRepository: &cotacaotest.StubCotacaoRepository{}}
+	queue := cotacao.NewWriteQueue(repository, 10, 5*time.Millisecond)
+	go queue.Run()
+	t.Cleanup(func() { queue.Close(context.Background()) })
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	enqueue := func(bids ...string) {
+		t.Helper()
+		for _, bid := range bids {
+			if err := queue.Enqueue(cotacao.StoredCotacao{Pair: cotacao.DefaultPair, Bid: bid, Source: cotacao.SourceLive}); err != nil {
+				t.Fatalf("Enqueue(%s): %v", bid, err)
+			}
+		}
+	}
+
+	// The rejected quote shares a batch with valid ones, which are still saved.
+	enqueue("5.0001", "invalid", "5.0002")
+	waitFor("the batch around the rejected quote", func() bool { return len(repository.Rows()) == 2 })
+	// Later batches are no longer held back by the rejected one.
+	enqueue("5.0003")
+	waitFor("the next batch", func() bool { return len(repository.Rows()) == 3 })
+
+	var bids []string
+	for _, row := range repository.Rows() {
+		bids = append(bids, row.Bid)
+	}
+	if want := []string{"5.0001", "5.0002", "5.0003"}; !slices.Equal(bids, want) {
+		t.Errorf("stored bids %v, want %v", bids, want)
+	}
+	if n := queue.Dropped(); n != 1 {
+		t.Errorf("Dropped = %d, want 1", n)
+	}
+	if !queue.Healthy() {
+		t.Error("queue unhealthy after dropping the rejected quote, want healthy")
+	}
+}
+
+func TestWriteQueueFull(t *testing.T) {
+	// Without Run nothing drains the buffer.
+	queue := cotacao.NewWriteQueue(&cotacaotest.StubCotacaoRepository{}, 2, time.Hour)
+	item := cotacao.StoredCotacao{Pair: cotacao.DefaultPair, Bid: "5.4300", Source: cotacao.SourceLive}
+
+	for i := 0; i < 2; i++ {
+		if err := queue.Enqueue(item); err != nil {
+			t.Fatalf("Enqueue %d: %v", i, err)
+		}
+	}
+	if err := queue.Enqueue(item); !errors.Is(err, cotacao.ErrQueueFull) {
+		t.Errorf("Enqueue over the buffer: err = %v, want %v", err, cotacao.ErrQueueFull)
+	}
+}
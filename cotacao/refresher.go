@@ -0,0 +1,113 @@
+package cotacao
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Refresher is a CotacaoFetcher that polls the wrapped fetcher for one pair
+// in the background and serves the latest result from memory. Other pairs,
+// and the pair itself before the first refresh, go to the wrapped fetcher.
+type Refresher struct {
+	fetcher      CotacaoFetcher
+	pair         string
+	interval     time.Duration
+	fetchTimeout time.Duration
+	ticks        func(interval time.Duration) (<-chan time.Time, func())
+	mu           sync.RWMutex
+	result       FetchResult
+	ready        bool
+}
+
+func NewRefresher(fetcher CotacaoFetcher, pair string, interval, fetchTimeout time.Duration) *Refresher {
+	return &Refresher{
+		fetcher:      fetcher,
+		pair:         pair,
+		interval:     interval,
+		fetchTimeout: fetchTimeout,
+		ticks:        newTicker,
+	}
+}
+
+// newTicker ticks every interval on a time.Ticker, returning its channel and
+// its Stop.
+func newTicker(interval time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+// Refresh fetches the pair once and stores the result. A failed fetch keeps
+// the previous value, or stores the fallback the fetcher returned when there
+// is none yet.
+func (r *Refresher) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.fetchTimeout)
+	defer cancel()
+
+	result, err := r.fetcher.Fetch(ctx, r.pair)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		if !r.ready && result.Bid != "" {
+			r.result, r.ready = result, true
+		}
+		return err
+	}
+	r.result, r.ready = result, true
+	return nil
+}
+
+// Run refreshes the pair every interval until ctx is done.
+func (r *Refresher) Run(ctx context.Context) {
+	ticks, stop := r.ticks(r.interval)
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+		}
+
+		if err := r.Refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error refreshing cotacao", "pair", r.pair, "error", err)
+		}
+	}
+}
+
+// Fetch serves the stored result for the pair. A context marked with
+// WithFresh goes to the wrapped fetcher, and a live result replaces the
+// stored one.
+func (r *Refresher) Fetch(ctx context.Context, pair string) (FetchResult, error) {
+	if pair == r.pair && isFresh(ctx) {
+		result, err := r.fetcher.Fetch(ctx, pair)
+		if err == nil && result.Source == SourceLive {
+			r.mu.Lock()
+			r.result, r.ready = result, true
+			r.mu.Unlock()
+		}
+		return result, err
+	}
+	if pair == r.pair {
+		r.mu.RLock()
+		result, ready := r.result, r.ready
+		r.mu.RUnlock()
+		if ready {
+			if result.Source == SourceLive {
+				result.Source, result.Latency = SourceCache, 0
+			}
+			return result, nil
+		}
+	}
+	return r.fetcher.Fetch(ctx, pair)
+}
+
+func (r *Refresher) Probe(ctx context.Context) error {
+	return probeOf(ctx, r.fetcher)
+}
+
+func (r *Refresher) State() CircuitState {
+	return stateOf(r.fetcher)
+}
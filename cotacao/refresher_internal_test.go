@@ -0,0 +1,74 @@
+package cotacao
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// chanFetcher answers each Fetch with the next bid sent on it.
+type chanFetcher chan string
+
+func (f chanFetcher) Fetch(ctx context.Context, pair string) (FetchResult, error) {
+	select {
+	case bid := <-f:
+		return FetchResult{Bid: bid, Source: SourceLive}, nil
+	case <-ctx.Done():
+		return FetchResult{}, ctx.Err()
+	}
+}
+
+func TestRefresherRunServesLatestTick(t *testing.T) {
+	db, err := OpenSQLite("file:" + t.Name() + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	bids := make(chanFetcher)
+	ticks := make(chan time.Time)
+	refresher := NewRefresher(bids, DefaultPair, time.Minute, time.Minute)
+	refresher.ticks = func(interval time.Duration) (<-chan time.Time, func()) {
+		if interval != time.Minute {
+			t.Errorf("ticks every %s, want the configured %s", interval, time.Minute)
+		}
+		return ticks, func() {}
+	}
+	server := NewServer(refresher, NewSQLiteCotacaoRepository(db), DefaultServerConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		refresher.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ticks <- now
+	for _, want := range []string{"5.0001", "5.0002", "5.0003"} {
+		bids <- want
+		// Run takes the next tick only after storing the previous refresh.
+		now = now.Add(time.Minute)
+		ticks <- now
+
+		rec := httptest.NewRecorder()
+		server.CotacaoHandler(rec, httptest.NewRequest(http.MethodGet, "/cotacao", nil))
+		var body struct {
+			Bid    string `json:"bid"`
+			Source string `json:"source"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding %q: %v", rec.Body, err)
+		}
+		if body.Bid != want || body.Source != SourceCache {
+			t.Errorf("after the tick at %s: bid %q from %q, want %q from %q", now.Format(time.Kitchen), body.Bid, body.Source, want, SourceCache)
+		}
+	}
+}
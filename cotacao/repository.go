@@ -0,0 +1,225 @@
+package cotacao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrSaveFailed wraps the errors repositories return when storing quotes.
+var ErrSaveFailed = errors.New("saving cotacao failed")
+
+// ErrDriverNotRegistered is returned when opening a database with a driver
+// name no imported package registered with database/sql.
+var ErrDriverNotRegistered = errors.New("database driver not registered")
+
+type StoredCotacao struct {
+	ID        int64     `json:"id"`
+	Pair      string    `json:"pair"`
+	Bid       string    `json:"bid"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+	// LatencyMs is how long the fetch that produced the quote took.
+	LatencyMs int64 `json:"latency_ms"`
+}
+
+// Stats aggregates the quotes in a window. An empty window has every field
+// zero.
+type Stats struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+}
+
+type CotacaoRepository interface {
+	// Save stores a quote, with the latency of the fetch that produced it,
+	// and returns its id.
+	Save(ctx context.Context, pair, bid, source string, fetchedAt time.Time, latency time.Duration) (int64, error)
+	List(ctx context.Context, limit int) ([]StoredCotacao, error)
+	ListAfter(ctx context.Context, afterID int64, limit int) ([]StoredCotacao, error)
+	Latest(ctx context.Context) (StoredCotacao, error)
+	LatestByPair(ctx context.Context, pair string) (StoredCotacao, error)
+	// Range returns up to limit quotes fetched between from and to
+	// inclusive, oldest first.
+	Range(ctx context.Context, from, to time.Time, limit int) ([]StoredCotacao, error)
+	SaveBatch(ctx context.Context, items []StoredCotacao) error
+	Prune(ctx context.Context, olderThan time.Time) (int64, error)
+	Average(ctx context.Context, n int) (float64, error)
+	// Page returns up to limit quotes with an id above afterID, oldest
+	// first, so callers can walk the whole table one query at a time.
+	Page(ctx context.Context, afterID int64, limit int) ([]StoredCotacao, error)
+	// Stats aggregates the quotes fetched at or after since.
+	Stats(ctx context.Context, since time.Time) (Stats, error)
+	Ping(ctx context.Context) error
+}
+
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+	}
+}
+
+// openDB checks that driver is registered before opening dsn with it, since
+// sql.Open would otherwise only fail on first use with a less helpful error.
+func openDB(driver, dsn string) (*sql.DB, error) {
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("%w: %q (registered: %s)", ErrDriverNotRegistered, driver, strings.Join(sql.Drivers(), ", "))
+	}
+	return sql.Open(driver, dsn)
+}
+
+func (p PoolConfig) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+}
+
+// batchChunkSize keeps each multi-row INSERT well below SQLite's default
+// limit on bound parameters.
+const batchChunkSize = 200
+
+// saveBatch inserts items inside a single transaction using multi-row VALUES
+// statements, rolling back if any chunk fails. placeholder renders the
+// driver-specific bind parameter for the given 1-based index.
+func saveBatch(ctx context.Context, db *sql.DB, items []StoredCotacao, placeholder func(n int) string) (err error) {
+	if len(items) == 0 {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrSaveFailed, err)
+		}
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(items); start += batchChunkSize {
+		chunk := items[start:min(start+batchChunkSize, len(items))]
+
+		var query strings.Builder
+		query.WriteString("INSERT INTO cotacao(pair, bid, source, timestamp, latency_ms) VALUES ")
+		args := make([]any, 0, len(chunk)*5)
+		for i, item := range chunk {
+			if i > 0 {
+				query.WriteString(", ")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "(%s, %s, %s, %s, %s)", placeholder(n+1), placeholder(n+2), placeholder(n+3), placeholder(n+4), placeholder(n+5))
+
+			pair, source := batchDefaults(item)
+			args = append(args, pair, item.Bid, source, fetchTimestamp(item.Timestamp), item.LatencyMs)
+		}
+
+		if _, err := tx.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// batchDefaults returns the item's pair and source, substituting DefaultPair
+// and SourceLive when they are empty.
+func batchDefaults(item StoredCotacao) (pair, source string) {
+	pair, source = item.Pair, item.Source
+	if pair == "" {
+		pair = DefaultPair
+	}
+	if source == "" {
+		source = SourceLive
+	}
+	return pair, source
+}
+
+// queryStats runs query, which selects the count, min, max and average of
+// the bids, zeroing the aggregates SQL reports as NULL for an empty window.
+func queryStats(ctx context.Context, db *sql.DB, query string, args ...any) (Stats, error) {
+	var stats Stats
+	var minBid, maxBid, avgBid sql.NullFloat64
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&stats.Count, &minBid, &maxBid, &avgBid); err != nil {
+		return Stats{}, err
+	}
+	stats.Min, stats.Max, stats.Avg = minBid.Float64, maxBid.Float64, avgBid.Float64
+	return stats, nil
+}
+
+func queryCotacoes(ctx context.Context, db *sql.DB, query string, args ...any) ([]StoredCotacao, error) {
+	cotacoes := []StoredCotacao{}
+	err := eachCotacao(ctx, db, func(c StoredCotacao) error {
+		cotacoes = append(cotacoes, c)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return cotacoes, nil
+}
+
+// eachCotacao scans the rows of query one at a time into fn.
+func eachCotacao(ctx context.Context, db *sql.DB, fn func(StoredCotacao) error, query string, args ...any) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c StoredCotacao
+		if err := rows.Scan(&c.ID, &c.Pair, &c.Bid, &c.Source, &c.Timestamp, &c.LatencyMs); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// fetchTimestamp normalizes fetchedAt to UTC, using the current time for the
+// zero value.
+func fetchTimestamp(fetchedAt time.Time) time.Time {
+	if fetchedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return fetchedAt.UTC()
+}
+
+// RunPruner deletes quotes older than retention every interval until ctx is
+// done.
+func RunPruner(ctx context.Context, repository CotacaoRepository, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		removed, err := repository.Prune(ctx, time.Now().UTC().Add(-retention))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error pruning old cotacoes", "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Pruned old cotacoes", "removed", removed, "retention", retention)
+	}
+}
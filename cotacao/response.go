@@ -0,0 +1,29 @@
+// Package cotacao fetches, stores and serves exchange rate quotes for the
+// client and server binaries under cmd/.
+package cotacao
+
+import "time"
+
+// Timing budget shared by the server and the client. A client waiting less
+// than MinClientTimeout can give up before the server finishes fetching and
+// saving a quote.
+const (
+	DefaultFetchTimeout  = 200 * time.Millisecond
+	DefaultSaveTimeout   = 10 * time.Millisecond
+	MinClientTimeout     = DefaultFetchTimeout + DefaultSaveTimeout
+	DefaultClientTimeout = 300 * time.Millisecond
+)
+
+// CorrelationIDHeader carries an ID chosen by the client that the server
+// logs and echoes, tying both sides' logs to one request.
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// CotacaoResponse is the JSON body returned by the server's /cotacao endpoint
+// and decoded by the client. FetchedAt is an RFC 3339 timestamp set by the
+// server; it and Pair are left out in legacy mode.
+type CotacaoResponse struct {
+	Bid       string `json:"bid"`
+	Pair      string `json:"pair,omitempty"`
+	FetchedAt string `json:"fetched_at,omitempty"`
+	Source    string `json:"source,omitempty"`
+}
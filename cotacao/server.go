@@ -0,0 +1,691 @@
+package cotacao
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+	defaultAverageN     = 10
+	exportPageSize      = 500
+	readyTimeout        = time.Second
+	healthProbeTimeout  = 500 * time.Millisecond
+)
+
+// DefaultBidScale is the number of decimal places bids are rounded to unless
+// configured otherwise.
+const DefaultBidScale = 4
+
+type ServerConfig struct {
+	FetchTimeout time.Duration
+	SaveTimeout  time.Duration
+	// AllowInjection enables POST /cotacao for storing quotes by hand.
+	AllowInjection bool
+	// BidScale is the number of decimal places bids are rounded to.
+	BidScale int32
+	// DryRun skips persisting fetched quotes, as ?dryRun=true does per
+	// request.
+	DryRun bool
+	// Dedupe skips storing a quote whose bid equals the latest stored bid for
+	// the same pair.
+	Dedupe bool
+	// LegacyResponse leaves pair and fetched_at out of /cotacao responses.
+	LegacyResponse bool
+	// TolerateSaveErrors serves a fetched quote with persisted:false when
+	// saving it fails, instead of answering 500.
+	TolerateSaveErrors bool
+	// WriteQueue, when set, makes CotacaoHandler enqueue quotes instead of
+	// saving them before responding.
+	WriteQueue *WriteQueue
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		FetchTimeout: DefaultFetchTimeout,
+		SaveTimeout:  DefaultSaveTimeout,
+		BidScale:     DefaultBidScale,
+	}
+}
+
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSON sets the Content-Type before WriteHeader so it is not dropped.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var ErrInvalidBid = errors.New("invalid bid")
+
+// parseBid checks that bid is a positive decimal number.
+func parseBid(bid string) (decimal.Decimal, error) {
+	value, err := decimal.NewFromString(bid)
+	if err != nil {
+		return decimal.Decimal{}, fmt.Errorf("%w: %q is not a number", ErrInvalidBid, bid)
+	}
+	if !value.IsPositive() {
+		return decimal.Decimal{}, fmt.Errorf("%w: %q is not positive", ErrInvalidBid, bid)
+	}
+	return value, nil
+}
+
+// NormalizeBid validates bid and renders it rounded to exactly scale decimal
+// places, so "5.43" and "5.4300" are stored and served the same way.
+func NormalizeBid(bid string, scale int32) (string, error) {
+	value, err := parseBid(bid)
+	if err != nil {
+		return "", err
+	}
+	return value.StringFixed(scale), nil
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, ErrorResponse{Code: code, Message: message})
+}
+
+type Server struct {
+	fetcher    CotacaoFetcher
+	repository CotacaoRepository
+	config     ServerConfig
+	stats      serverStats
+}
+
+func NewServer(fetcher CotacaoFetcher, repository CotacaoRepository, config ServerConfig) *Server {
+	defaults := DefaultServerConfig()
+	if config.FetchTimeout <= 0 {
+		config.FetchTimeout = defaults.FetchTimeout
+	}
+	if config.SaveTimeout <= 0 {
+		config.SaveTimeout = defaults.SaveTimeout
+	}
+	if config.BidScale <= 0 {
+		config.BidScale = defaults.BidScale
+	}
+	return &Server{fetcher: fetcher, repository: repository, config: config}
+}
+
+func (s *Server) circuitState() CircuitState {
+	return stateOf(s.fetcher)
+}
+
+// retryAfterSeconds is the remaining circuit cooldown rounded up, at least 1
+// so a half-open circuit with a probe in flight is retried shortly.
+func (s *Server) retryAfterSeconds() int {
+	return max(1, int(math.Ceil(s.circuitState().ResetIn.Seconds())))
+}
+
+type fetchResponse struct {
+	CotacaoResponse
+	Persisted bool `json:"persisted"`
+	Queued    bool `json:"queued,omitempty"`
+	Duplicate bool `json:"duplicate,omitempty"`
+	*quoteDetails
+}
+
+// quoteDetails are the upstream fields beyond the bid, sent for ?full=true.
+type quoteDetails struct {
+	Ask       string `json:"ask,omitempty"`
+	High      string `json:"high,omitempty"`
+	Low       string `json:"low,omitempty"`
+	VarBid    string `json:"varBid,omitempty"`
+	PctChange string `json:"pctChange,omitempty"`
+}
+
+// isDuplicate reports whether bid equals the latest stored bid for pair.
+// Lookup errors are logged and treated as not a duplicate.
+func (s *Server) isDuplicate(ctx context.Context, pair, bid string) bool {
+	latest, err := s.repository.LatestByPair(ctx, pair)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.WarnContext(ctx, "Error reading latest cotacao for dedupe", "pair", pair, "error", err)
+		}
+		return false
+	}
+	return latest.Bid == bid
+}
+
+func (s *Server) CotacaoHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s.injectCotacao(w, r)
+		return
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "Server.CotacaoHandler", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	r = r.WithContext(ctx)
+
+	pair := r.URL.Query().Get("pair")
+	if pair == "" {
+		pair = DefaultPair
+	}
+	span.SetAttributes(attribute.String("cotacao.pair", pair))
+	if !pairPattern.MatchString(pair) {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAIR", "Invalid pair, expected format like USD-BRL")
+		return
+	}
+	dryRun := s.config.DryRun
+	if v := r.URL.Query().Get("dryRun"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_DRY_RUN", "Invalid dryRun, expected true or false")
+			return
+		}
+		dryRun = dryRun || parsed
+	}
+	full := false
+	if v := r.URL.Query().Get("full"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_FULL", "Invalid full, expected true or false")
+			return
+		}
+		full = parsed
+	}
+	fresh := strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+	if v := r.URL.Query().Get("fresh"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_FRESH", "Invalid fresh, expected true or false")
+			return
+		}
+		fresh = fresh || parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.FetchTimeout)
+	defer cancel()
+	if fresh {
+		ctx = WithFresh(ctx)
+	}
+
+	s.stats.requests.Add(1)
+	start := time.Now()
+	result, err := s.fetcher.Fetch(ctx, pair)
+	s.stats.recordFetch(result, err, time.Since(start))
+	if errors.Is(err, ErrUnknownPair) {
+		writeJSONError(w, http.StatusBadRequest, "UNKNOWN_PAIR", "Unknown pair "+pair)
+		return
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(s.retryAfterSeconds()))
+		writeJSONError(w, http.StatusServiceUnavailable, "CIRCUIT_OPEN", "Cotacao upstream circuit breaker is open, retry later")
+		return
+	}
+	if errors.Is(err, ErrTooManyFetches) {
+		w.Header().Set("Retry-After", "1")
+		writeJSONError(w, http.StatusServiceUnavailable, "TOO_MANY_FETCHES", "Too many concurrent cotacao fetches, retry later")
+		return
+	}
+	if errors.Is(err, ErrUpstreamUnavailable) {
+		slog.ErrorContext(r.Context(), "Upstream unavailable", "pair", pair, "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "UPSTREAM_UNAVAILABLE", "Cotacao upstream unavailable")
+		return
+	}
+	if errors.Is(err, ErrDecodeFailed) {
+		slog.ErrorContext(r.Context(), "Invalid upstream response", "pair", pair, "error", err)
+		writeJSONError(w, http.StatusBadGateway, "DECODE_FAILED", "Cotacao upstream returned an invalid response")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching cotacao", "pair", pair, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "FETCH_FAILED", "Failed to fetch cotacao")
+		return
+	}
+
+	span.SetAttributes(attribute.String("cotacao.source", result.Source))
+	bid, err := NormalizeBid(result.Bid, s.config.BidScale)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Invalid bid from fetcher", "pair", pair, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "FETCH_FAILED", "Failed to fetch cotacao: invalid bid")
+		return
+	}
+
+	fetchedAt := time.Now().UTC()
+	response := fetchResponse{CotacaoResponse: CotacaoResponse{Bid: bid, Source: result.Source}}
+	if !s.config.LegacyResponse {
+		response.Pair = pair
+		response.FetchedAt = fetchedAt.Format(time.RFC3339)
+	}
+	if full {
+		quote := result.Quote
+		response.quoteDetails = &quoteDetails{Ask: quote.Ask, High: quote.High, Low: quote.Low, VarBid: quote.VarBid, PctChange: quote.PctChange}
+	}
+
+	if dryRun {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	dbCtx, dbCancel := context.WithTimeout(r.Context(), s.config.SaveTimeout)
+	defer dbCancel()
+
+	if s.config.Dedupe && s.isDuplicate(dbCtx, pair, bid) {
+		slog.DebugContext(r.Context(), "Skipping duplicate cotacao", "pair", pair, "bid", bid)
+		response.Duplicate = true
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if s.config.WriteQueue != nil {
+		if err := s.config.WriteQueue.Enqueue(StoredCotacao{Pair: pair, Bid: bid, Source: result.Source, Timestamp: fetchedAt, LatencyMs: result.Latency.Milliseconds()}); err != nil {
+			slog.ErrorContext(r.Context(), "Error queueing cotacao", "pair", pair, "error", err)
+			writeJSONError(w, http.StatusServiceUnavailable, "QUEUE_FULL", "Too many pending writes, retry later")
+			return
+		}
+		response.Queued = true
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if _, err := s.repository.Save(dbCtx, pair, bid, result.Source, fetchedAt, result.Latency); err != nil {
+		slog.ErrorContext(r.Context(), "Error saving cotacao", "pair", pair, "error", err)
+		if s.config.TolerateSaveErrors {
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeJSONError(w, http.StatusInternalServerError, "SAVE_FAILED", fmt.Sprintf("Failed to save cotacao: timed out after %s", s.config.SaveTimeout))
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "SAVE_FAILED", "Failed to save cotacao")
+		return
+	}
+
+	response.Persisted = true
+	writeJSON(w, http.StatusOK, response)
+}
+
+type injectRequest struct {
+	Pair string `json:"pair"`
+	Bid  string `json:"bid"`
+}
+
+func (s *Server) injectCotacao(w http.ResponseWriter, r *http.Request) {
+	if !s.config.AllowInjection {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", "Manual cotacao injection is disabled")
+		return
+	}
+
+	var req injectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_BODY", "Invalid JSON body")
+		return
+	}
+	if req.Pair == "" {
+		req.Pair = DefaultPair
+	}
+	if !pairPattern.MatchString(req.Pair) {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_PAIR", "Invalid pair, expected format like USD-BRL")
+		return
+	}
+	bid, err := NormalizeBid(req.Bid, s.config.BidScale)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_BID", "Invalid bid, expected a positive number")
+		return
+	}
+
+	stored := StoredCotacao{Pair: req.Pair, Bid: bid, Source: SourceManual, Timestamp: time.Now().UTC()}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.SaveTimeout)
+	defer cancel()
+
+	stored.ID, err = s.repository.Save(ctx, stored.Pair, stored.Bid, stored.Source, stored.Timestamp, 0)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error saving injected cotacao", "pair", stored.Pair, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "SAVE_FAILED", "Failed to save cotacao")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Injected cotacao", "id", stored.ID, "pair", stored.Pair, "bid", stored.Bid)
+	writeJSON(w, http.StatusCreated, stored)
+}
+
+type historyResponse struct {
+	Cotacoes []StoredCotacao `json:"cotacoes"`
+	Next     *int64          `json:"next,omitempty"`
+}
+
+// limitParam parses the optional ?limit query parameter, capped at
+// maxHistoryLimit.
+func limitParam(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultHistoryLimit, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return min(n, maxHistoryLimit), true
+}
+
+func (s *Server) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit, ok := limitParam(r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_LIMIT", "Invalid limit")
+		return
+	}
+
+	var after int64
+	if v := r.URL.Query().Get("after"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_CURSOR", "Invalid after cursor")
+			return
+		}
+		after = id
+	}
+
+	// Ask for one extra row to know whether another page exists.
+	cotacoes, err := s.repository.ListAfter(r.Context(), after, limit+1)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing cotacoes", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "LIST_FAILED", "Failed to list cotacoes")
+		return
+	}
+
+	response := historyResponse{Cotacoes: cotacoes}
+	if len(cotacoes) > limit {
+		response.Cotacoes = cotacoes[:limit]
+		response.Next = &cotacoes[limit-1].ID
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type rangeResponse struct {
+	Cotacoes []StoredCotacao `json:"cotacoes"`
+}
+
+func (s *Server) RangeHandler(w http.ResponseWriter, r *http.Request) {
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_RANGE", "Invalid from, expected an RFC3339 timestamp")
+		return
+	}
+	to, err := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_RANGE", "Invalid to, expected an RFC3339 timestamp")
+		return
+	}
+	if from.After(to) {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_RANGE", "from must not be after to")
+		return
+	}
+	limit, ok := limitParam(r)
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "INVALID_LIMIT", "Invalid limit")
+		return
+	}
+
+	cotacoes, err := s.repository.Range(r.Context(), from, to, limit)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing cotacoes in range", "from", from, "to", to, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "LIST_FAILED", "Failed to list cotacoes")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rangeResponse{Cotacoes: cotacoes})
+}
+
+func (s *Server) LatestHandler(w http.ResponseWriter, r *http.Request) {
+	latest, err := s.repository.Latest(r.Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "NOT_FOUND", "No cotacao stored yet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading latest cotacao", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "LATEST_FAILED", "Failed to read latest cotacao")
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d-%s"`, latest.ID, latest.Bid)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, latest)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison RFC 9110 prescribes for it.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportHandler streams every stored quote as a CSV attachment, reading them
+// in pages of exportPageSize by id. Each page is a query of its own, so a slow
+// download does not hold the database connection, SQLite's only one, between
+// pages.
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="cotacoes.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "pair", "bid", "timestamp"})
+	rows := 0
+	// Paging by id rather than one rows.Next() cursor over the whole table
+	// is deliberate. SQLite runs on a single connection (sqlitePool), and a
+	// cursor held for the length of a slow download would block every save
+	// behind it. The price is that the export is not a snapshot: quotes
+	// saved while it runs get higher ids and show up in a later page.
+	err := s.exportPages(r.Context(), func(c StoredCotacao) error {
+		rows++
+		return cw.Write([]string{strconv.FormatInt(c.ID, 10), c.Pair, c.Bid, c.Timestamp.UTC().Format(time.RFC3339)})
+	})
+	if err != nil && rows == 0 {
+		// Nothing has reached the client yet, so it can still get an error.
+		slog.ErrorContext(r.Context(), "Error exporting cotacoes", "error", err)
+		w.Header().Del("Content-Disposition")
+		writeJSONError(w, http.StatusInternalServerError, "EXPORT_FAILED", "Failed to export cotacoes")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error exporting cotacoes, response truncated", "rows", rows, "error", err)
+	}
+	cw.Flush()
+}
+
+// exportPages calls fn for every stored quote, oldest first, fetching them a
+// page at a time and stopping at the first error.
+func (s *Server) exportPages(ctx context.Context, fn func(StoredCotacao) error) error {
+	var afterID int64
+	for {
+		page, err := s.repository.Page(ctx, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, c := range page {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
+
+// QuoteStatsHandler aggregates the stored quotes fetched since the RFC3339
+// ?since= timestamp, or all of them when it is absent.
+func (s *Server) QuoteStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_SINCE", "Invalid since, expected an RFC3339 timestamp")
+			return
+		}
+		since = parsed
+	}
+
+	stats, err := s.repository.Stats(r.Context(), since)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error computing cotacao stats", "since", since, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "STATS_FAILED", "Failed to compute cotacao stats")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+type averageResponse struct {
+	N       int     `json:"n"`
+	Average float64 `json:"average"`
+}
+
+func (s *Server) AverageHandler(w http.ResponseWriter, r *http.Request) {
+	n := defaultAverageN
+	if v := r.URL.Query().Get("n"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_N", "Invalid n")
+			return
+		}
+		n = min(parsed, maxHistoryLimit)
+	}
+
+	avg, err := s.repository.Average(r.Context(), n)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "NOT_FOUND", "No cotacao stored yet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error computing average cotacao", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "AVERAGE_FAILED", "Failed to compute average cotacao")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, averageResponse{N: n, Average: avg})
+}
+
+type readyResponse struct {
+	Status string `json:"status"`
+}
+
+// ReadyHandler reports whether the database is reachable and, with a write
+// queue, whether it is saving, independently of the upstream circuit reported
+// by HealthHandler.
+func (s *Server) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	if err := s.repository.Ping(ctx); err != nil {
+		slog.ErrorContext(r.Context(), "Database not reachable", "error", err)
+		writeJSONError(w, http.StatusServiceUnavailable, "NOT_READY", "Database not reachable")
+		return
+	}
+	if s.config.WriteQueue != nil && !s.config.WriteQueue.Healthy() {
+		writeJSONError(w, http.StatusServiceUnavailable, "NOT_READY", "Write queue is retrying failed saves")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, readyResponse{Status: "ready"})
+}
+
+type healthResponse struct {
+	Circuit        string  `json:"circuit"`
+	FailureCount   int     `json:"failure_count"`
+	ResetInSeconds float64 `json:"reset_in_seconds"`
+	// Upstream and Database are only checked with ?deep=true.
+	Upstream *componentHealth `json:"upstream,omitempty"`
+	Database *componentHealth `json:"database,omitempty"`
+}
+
+// componentHealth is "ok", "unreachable" or, for an upstream the fetcher
+// cannot probe, "unknown".
+type componentHealth struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func newComponentHealth(err error) *componentHealth {
+	switch {
+	case err == nil:
+		return &componentHealth{Status: "ok"}
+	case errors.Is(err, ErrProbeUnsupported):
+		return &componentHealth{Status: "unknown"}
+	}
+	return &componentHealth{Status: "unreachable", Error: err.Error()}
+}
+
+// probeUpstream runs the fetcher's upstream probe within healthProbeTimeout.
+func (s *Server) probeUpstream(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	return probeOf(ctx, s.fetcher)
+}
+
+func (s *Server) pingDatabase(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	return s.repository.Ping(ctx)
+}
+
+// HealthHandler reports the circuit breaker state. With ?deep=true it also
+// probes the upstream and pings the database, answering 503 when either is
+// unreachable.
+func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	deep := false
+	if v := r.URL.Query().Get("deep"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_DEEP", "Invalid deep, expected true or false")
+			return
+		}
+		deep = parsed
+	}
+
+	state := s.circuitState()
+
+	response := healthResponse{
+		Circuit:        state.String(),
+		FailureCount:   state.FailureCount,
+		ResetInSeconds: state.ResetIn.Seconds(),
+	}
+	status := http.StatusOK
+	if state.Open {
+		status = http.StatusServiceUnavailable
+	}
+
+	if deep {
+		response.Upstream = newComponentHealth(s.probeUpstream(r.Context()))
+		response.Database = newComponentHealth(s.pingDatabase(r.Context()))
+		if response.Upstream.Status == "unreachable" || response.Database.Status == "unreachable" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,1221 @@
+package cotacao_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+// serve runs one request against handler and returns the recorded response.
+func serve(t *testing.T, handler http.HandlerFunc, method, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+// decode unmarshals the JSON body of rec into a map.
+func decode(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding body %q: %v", rec.Body.String(), err)
+	}
+	return body
+}
+
+func TestCotacaoHandlerServesStubbedBid(t *testing.T) {
+	fetcher := &cotacaotest.StubCotacaoFetcher{Bid: "5.43"}
+	repository := &cotacaotest.StubCotacaoRepository{}
+	server := cotacao.NewServer(fetcher, repository, cotacao.DefaultServerConfig())
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	if body := decode(t, rec); body["bid"] != "5.4300" || body["source"] != cotacao.SourceLive {
+		t.Errorf("body = %v, want bid 5.4300 from %s", body, cotacao.SourceLive)
+	}
+	if pairs := fetcher.Pairs(); len(pairs) != 1 || pairs[0] != cotacao.DefaultPair {
+		t.Errorf("fetched pairs = %v, want [%s]", pairs, cotacao.DefaultPair)
+	}
+	rows := repository.Rows()
+	if len(rows) != 1 || rows[0].Bid != "5.4300" {
+		t.Errorf("stored rows = %+v, want one with bid 5.4300", rows)
+	}
+}
+
+func TestCotacaoHandlerFetchError(t *testing.T) {
+	fetcher := &cotacaotest.StubCotacaoFetcher{Err: errors.New("upstream exploded")}
+	repository := &cotacaotest.StubCotacaoRepository{}
+	server := cotacao.NewServer(fetcher, repository, cotacao.DefaultServerConfig())
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if body := decode(t, rec); body["code"] != "FETCH_FAILED" {
+		t.Errorf("code = %v, want FETCH_FAILED", body["code"])
+	}
+	if n := repository.Calls("Save"); n != 0 {
+		t.Errorf("Save called %d times after a failed fetch", n)
+	}
+}
+
+func TestCotacaoHandlerSaveError(t *testing.T) {
+	fetcher := &cotacaotest.StubCotacaoFetcher{Bid: "5.43"}
+	repository := &cotacaotest.StubCotacaoRepository{Err: errors.New("disk full")}
+	server := cotacao.NewServer(fetcher, repository, cotacao.DefaultServerConfig())
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if body := decode(t, rec); body["code"] != "SAVE_FAILED" {
+		t.Errorf("code = %v, want SAVE_FAILED", body["code"])
+	}
+	if n := repository.Calls("Save"); n != 1 {
+		t.Errorf("Save called %d times, want 1", n)
+	}
+}
+
+func TestCotacaoHandlerDryRun(t *testing.T) {
+	dryRunConfig := cotacao.DefaultServerConfig()
+	dryRunConfig.DryRun = true
+
+	tests := []struct {
+		name          string
+		config        cotacao.ServerConfig
+		target        string
+		wantPersisted bool
+	}{
+		{"query param", cotacao.DefaultServerConfig(), "/cotacao?dryRun=true", false},
+		{"config flag", dryRunConfig, "/cotacao", false},
+		{"config flag wins over the param", dryRunConfig, "/cotacao?dryRun=false", false},
+		{"param off", cotacao.DefaultServerConfig(), "/cotacao?dryRun=false", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := &cotacaotest.StubCotacaoRepository{}
+			server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, repository, tt.config)
+
+			rec := serve(t, server.CotacaoHandler, http.MethodGet, tt.target)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			if body := decode(t, rec); body["bid"] != "5.4300" || body["persisted"] != tt.wantPersisted {
+				t.Errorf("body = %v, want bid 5.4300 with persisted %v", body, tt.wantPersisted)
+			}
+			wantRows := 0
+			if tt.wantPersisted {
+				wantRows = 1
+			}
+			if rows := repository.Rows(); len(rows) != wantRows {
+				t.Errorf("stored %d rows, want %d", len(rows), wantRows)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		repository := &cotacaotest.StubCotacaoRepository{}
+		server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, repository, cotacao.DefaultServerConfig())
+
+		rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao?dryRun=maybe")
+
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		if body := decode(t, rec); body["code"] != "INVALID_DRY_RUN" {
+			t.Errorf("code = %v, want INVALID_DRY_RUN", body["code"])
+		}
+		if n := repository.Calls("Save"); n != 0 {
+			t.Errorf("Save called %d times for a rejected request", n)
+		}
+	})
+}
+
+func TestCotacaoHandlerDedupe(t *testing.T) {
+	tests := []struct {
+		name     string
+		dedupe   bool
+		wantRows []string
+	}{
+		{"on", true, []string{"5.4300", "5.4400", "5.4300"}},
+		{"off", false, []string{"5.4300", "5.4300", "5.4400", "5.4300"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := cotacao.DefaultServerConfig()
+			config.Dedupe = tt.dedupe
+			fetcher := &cotacaotest.StubCotacaoFetcher{}
+			repository := &cotacaotest.StubCotacaoRepository{}
+			server := cotacao.NewServer(fetcher, repository, config)
+
+			for i, bid := range []string{"5.43", "5.43", "5.44", "5.43"} {
+				fetcher.Bid = bid
+				rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+				if rec.Code != http.StatusOK {
+					t.Fatalf("fetch %d: status = %d, want %d; body %s", i+1, rec.Code, http.StatusOK, rec.Body)
+				}
+				// Only the repeated second bid is a consecutive duplicate.
+				wantDuplicate := tt.dedupe && i == 1
+				if body := decode(t, rec); (body["duplicate"] == true) != wantDuplicate {
+					t.Errorf("fetch %d: body = %v, want duplicate %v", i+1, body, wantDuplicate)
+				}
+			}
+
+			var bids []string
+			for _, row := range repository.Rows() {
+				bids = append(bids, row.Bid)
+			}
+			if !slices.Equal(bids, tt.wantRows) {
+				t.Errorf("stored bids = %v, want %v", bids, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestCotacaoHandlerFull(t *testing.T) {
+	h := newHarness(t, `{"USDBRL":{"code":"USD","codein":"BRL","bid":"5.4321","ask":"5.4331","high":"5.5000","low":"5.4000","varBid":"0.0121","pctChange":"0.22"}}`)
+
+	rec := serve(t, h.Handler.ServeHTTP, http.MethodGet, "/cotacao?full=true")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	body := decode(t, rec)
+	want := map[string]string{"bid": "5.4321", "ask": "5.4331", "high": "5.5000", "low": "5.4000", "varBid": "0.0121", "pctChange": "0.22"}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("%s = %v, want %s", key, body[key], value)
+		}
+	}
+
+	rec = serve(t, h.Handler.ServeHTTP, http.MethodGet, "/cotacao")
+	body = decode(t, rec)
+	for key := range want {
+		if _, ok := body[key]; ok && key != "bid" {
+			t.Errorf("default response has %s = %v, want only the minimal fields", key, body[key])
+		}
+	}
+	if body["bid"] != "5.4321" {
+		t.Errorf("default response bid = %v, want 5.4321", body["bid"])
+	}
+
+	if rec := serve(t, h.Handler.ServeHTTP, http.MethodGet, "/cotacao?full=maybe"); rec.Code != http.StatusBadRequest || decode(t, rec)["code"] != "INVALID_FULL" {
+		t.Errorf("?full=maybe: status = %d, body %s; want %d INVALID_FULL", rec.Code, rec.Body, http.StatusBadRequest)
+	}
+}
+
+func TestCotacaoHandlerToleratesSaveErrors(t *testing.T) {
+	config := cotacao.DefaultServerConfig()
+	config.TolerateSaveErrors = true
+	repository := &cotacaotest.StubCotacaoRepository{Err: errors.New("disk full")}
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, repository, config)
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if body := decode(t, rec); body["bid"] != "5.4300" || body["persisted"] != false {
+		t.Errorf("body = %v, want bid 5.4300 with persisted false", body)
+	}
+	if n := repository.Calls("Save"); n != 1 {
+		t.Errorf("Save called %d times, want 1", n)
+	}
+}
+
+func TestCotacaoHandlerEnvelope(t *testing.T) {
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+	before := time.Now().UTC().Truncate(time.Second)
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+	body := decode(t, rec)
+	if body["bid"] != "5.4300" || body["pair"] != cotacao.DefaultPair || body["source"] != cotacao.SourceLive {
+		t.Errorf("body = %v, want bid 5.4300 for %s from %s", body, cotacao.DefaultPair, cotacao.SourceLive)
+	}
+	raw, _ := body["fetched_at"].(string)
+	fetchedAt, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		t.Fatalf("fetched_at %q is not RFC3339: %v", raw, err)
+	}
+	if fetchedAt.Before(before) || fetchedAt.After(time.Now()) || !strings.HasSuffix(raw, "Z") {
+		t.Errorf("fetched_at = %s, want the UTC time of the request, after %s", raw, before.Format(time.RFC3339))
+	}
+
+	legacy := cotacao.DefaultServerConfig()
+	legacy.LegacyResponse = true
+	server = cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, &cotacaotest.StubCotacaoRepository{}, legacy)
+	body = decode(t, serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao"))
+	for _, key := range []string{"pair", "fetched_at"} {
+		if _, ok := body[key]; ok {
+			t.Errorf("legacy response has %s = %v", key, body[key])
+		}
+	}
+	if body["bid"] != "5.4300" {
+		t.Errorf("legacy bid = %v, want 5.4300", body["bid"])
+	}
+}
+
+func TestCotacaoHandlerMapsErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		fetchErr   error
+		saveErr    error
+		wantStatus int
+		wantCode   string
+	}{
+		{"circuit open", fmt.Errorf("%w: %w", cotacao.ErrUpstreamUnavailable, cotacao.ErrCircuitOpen), nil, http.StatusServiceUnavailable, "CIRCUIT_OPEN"},
+		{"upstream unavailable", fmt.Errorf("%w: status 503", cotacao.ErrUpstreamUnavailable), nil, http.StatusServiceUnavailable, "UPSTREAM_UNAVAILABLE"},
+		{"decode failed", fmt.Errorf("%w: unexpected EOF", cotacao.ErrDecodeFailed), nil, http.StatusBadGateway, "DECODE_FAILED"},
+		{"unknown pair", fmt.Errorf("%w: XXX-YYY", cotacao.ErrUnknownPair), nil, http.StatusBadRequest, "UNKNOWN_PAIR"},
+		{"other fetch error", errors.New("upstream exploded"), nil, http.StatusInternalServerError, "FETCH_FAILED"},
+		{"save failed", nil, fmt.Errorf("%w: disk full", cotacao.ErrSaveFailed), http.StatusInternalServerError, "SAVE_FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &cotacaotest.StubCotacaoFetcher{Bid: "5.43", Err: tt.fetchErr}
+			server := cotacao.NewServer(fetcher, &cotacaotest.StubCotacaoRepository{Err: tt.saveErr}, cotacao.DefaultServerConfig())
+
+			rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if body := decode(t, rec); body["code"] != tt.wantCode {
+				t.Errorf("code = %v, want %s", body["code"], tt.wantCode)
+			}
+		})
+	}
+}
+
+// seed stores n USD-BRL quotes a second apart in h's repository.
+func seed(t *testing.T, h *cotacaotest.Harness, n int) {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	items := make([]cotacao.StoredCotacao, n)
+	for i := range items {
+		items[i] = cotacao.StoredCotacao{
+			Pair:      cotacao.DefaultPair,
+			Bid:       fmt.Sprintf("5.%04d", i),
+			Source:    cotacao.SourceLive,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}
+	}
+	if err := h.Repository.SaveBatch(context.Background(), items); err != nil {
+		t.Fatalf("SaveBatch: %v", err)
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+	seed(t, h, 600)
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/cotacao/history", 50},
+		{"/cotacao/history?limit=3", 3},
+		{"/cotacao/history?limit=1000", 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := serve(t, h.Server.HistoryHandler, http.MethodGet, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			var body struct {
+				Cotacoes []cotacao.StoredCotacao `json:"cotacoes"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %q: %v", rec.Body, err)
+			}
+			if len(body.Cotacoes) != tt.want {
+				t.Fatalf("got %d cotacoes, want %d", len(body.Cotacoes), tt.want)
+			}
+			if newest := body.Cotacoes[0]; newest.Bid != "5.0599" {
+				t.Errorf("first cotacao = %+v, want the newest (bid 5.0599)", newest)
+			}
+		})
+	}
+}
+
+func TestHistoryHandlerInvalidLimit(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+
+	rec := serve(t, h.Server.HistoryHandler, http.MethodGet, "/cotacao/history?limit=abc")
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if body := decode(t, rec); body["code"] != "INVALID_LIMIT" {
+		t.Errorf("code = %v, want INVALID_LIMIT", body["code"])
+	}
+}
+
+func TestRangeHandler(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+	seed(t, h, 10)
+
+	tests := []struct {
+		name     string
+		query    string
+		status   int
+		code     string
+		wantBids []string
+	}{
+		{"valid", "from=2024-01-01T12:00:02Z&to=2024-01-01T12:00:05Z", http.StatusOK, "", []string{"5.0002", "5.0003", "5.0004", "5.0005"}},
+		{"offset timestamps", "from=2024-01-01T09:00:08-03:00&to=2024-01-01T09:00:30-03:00", http.StatusOK, "", []string{"5.0008", "5.0009"}},
+		{"inverted", "from=2024-01-01T12:00:05Z&to=2024-01-01T12:00:02Z", http.StatusBadRequest, "INVALID_RANGE", nil},
+		{"malformed from", "from=2024-01-01&to=2024-01-01T12:00:05Z", http.StatusBadRequest, "INVALID_RANGE", nil},
+		{"malformed to", "from=2024-01-01T12:00:02Z&to=yesterday", http.StatusBadRequest, "INVALID_RANGE", nil},
+		{"missing", "", http.StatusBadRequest, "INVALID_RANGE", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h.Server.RangeHandler, http.MethodGet, "/cotacao/range?"+tt.query)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				if body := decode(t, rec); body["code"] != tt.code {
+					t.Errorf("code = %v, want %s", body["code"], tt.code)
+				}
+				return
+			}
+			var body struct {
+				Cotacoes []cotacao.StoredCotacao `json:"cotacoes"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %q: %v", rec.Body, err)
+			}
+			var bids []string
+			for _, c := range body.Cotacoes {
+				bids = append(bids, c.Bid)
+			}
+			if !slices.Equal(bids, tt.wantBids) {
+				t.Errorf("bids = %v, want %v", bids, tt.wantBids)
+			}
+		})
+	}
+}
+
+// pairServer builds a Server whose fetcher talks to an upstream that knows
+// only EUR-BRL and answers 404 for any other pair, as AwesomeAPI does.
+func pairServer(t *testing.T) *cotacao.Server {
+	t.Helper()
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/json/last/EUR-BRL" {
+			http.NotFound(w, r)
+			return
+		}
+		servePayload(`{"EURBRL":{"code":"EUR","codein":"BRL","bid":"6.1234"}}`)(w, r)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/")
+	return cotacao.NewServer(fetcher, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+}
+
+func TestCotacaoHandlerPair(t *testing.T) {
+	server := pairServer(t)
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao?pair=EUR-BRL")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if body := decode(t, rec); body["bid"] != "6.1234" || body["pair"] != "EUR-BRL" {
+		t.Errorf("body = %v, want bid 6.1234 for EUR-BRL", body)
+	}
+}
+
+func TestCotacaoHandlerRejectsPair(t *testing.T) {
+	server := pairServer(t)
+
+	tests := []struct {
+		target string
+		code   string
+	}{
+		{"/cotacao?pair=XYZ-BRL", "UNKNOWN_PAIR"},
+		{"/cotacao?pair=usdbrl", "INVALID_PAIR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := serve(t, server.CotacaoHandler, http.MethodGet, tt.target)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			if body := decode(t, rec); body["code"] != tt.code {
+				t.Errorf("code = %v, want %s", body["code"], tt.code)
+			}
+		})
+	}
+}
+
+func TestCotacaoHandlerSaveTimeout(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+
+	tests := []struct {
+		timeout time.Duration
+		status  int
+	}{
+		{time.Nanosecond, http.StatusInternalServerError},
+		{5 * time.Second, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.timeout.String(), func(t *testing.T) {
+			config := cotacao.DefaultServerConfig()
+			config.SaveTimeout = tt.timeout
+			server := cotacao.NewServer(h.Fetcher, h.Repository, config)
+
+			rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			body := decode(t, rec)
+			if want := "Failed to save cotacao: timed out after 1ns"; body["code"] != "SAVE_FAILED" || body["message"] != want {
+				t.Errorf("body = %v, want SAVE_FAILED with %q", body, want)
+			}
+		})
+	}
+}
+
+// blockingFetcher blocks each Fetch until its context ends and reports the
+// context's error on observed.
+type blockingFetcher struct {
+	started  chan struct{}
+	observed chan error
+}
+
+func (f *blockingFetcher) Fetch(ctx context.Context, pair string) (cotacao.FetchResult, error) {
+	close(f.started)
+	<-ctx.Done()
+	f.observed <- ctx.Err()
+	return cotacao.FetchResult{}, ctx.Err()
+}
+
+func TestCotacaoHandlerPropagatesCancellation(t *testing.T) {
+	fetcher := &blockingFetcher{started: make(chan struct{}), observed: make(chan error, 1)}
+	config := cotacao.DefaultServerConfig()
+	config.FetchTimeout = time.Minute
+	server := cotacao.NewServer(fetcher, &cotacaotest.StubCotacaoRepository{}, config)
+	srv := httptest.NewServer(http.HandlerFunc(server.CotacaoHandler))
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-fetcher.started
+		cancel()
+	}()
+	if resp, err := srv.Client().Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("request succeeded despite being cancelled")
+	}
+
+	select {
+	case err := <-fetcher.observed:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("fetcher observed %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetcher context was not cancelled with the request")
+	}
+}
+
+func TestHealthHandlerReportsCircuit(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(0),
+		cotacao.WithFailureThreshold(2),
+		cotacao.WithResetTime(time.Minute),
+	)
+	server := cotacao.NewServer(fetcher, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+
+	for i, tt := range []struct {
+		status  int
+		circuit string
+		count   float64
+	}{
+		{http.StatusOK, "closed", 0},
+		{http.StatusOK, "closed", 1},
+		{http.StatusServiceUnavailable, "open", 2},
+	} {
+		if i > 0 {
+			fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+		}
+		rec := serve(t, server.HealthHandler, http.MethodGet, "/health")
+		if rec.Code != tt.status {
+			t.Fatalf("after %d failed fetches: status = %d, want %d", i, rec.Code, tt.status)
+		}
+		body := decode(t, rec)
+		if body["circuit"] != tt.circuit || body["failure_count"] != tt.count {
+			t.Errorf("after %d failed fetches: body = %v, want circuit %s with %v failures", i, body, tt.circuit, tt.count)
+		}
+		if tt.circuit == "open" {
+			if reset := body["reset_in_seconds"].(float64); reset <= 0 || reset > 60 {
+				t.Errorf("reset_in_seconds = %v, want within the 1m cooldown", reset)
+			}
+		}
+	}
+}
+
+func TestCotacaoHandlerErrorResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		fetcher    *cotacaotest.StubCotacaoFetcher
+		repository *cotacaotest.StubCotacaoRepository
+		want       cotacao.ErrorResponse
+	}{
+		{
+			name:       "fetch",
+			fetcher:    &cotacaotest.StubCotacaoFetcher{Err: errors.New("upstream exploded")},
+			repository: &cotacaotest.StubCotacaoRepository{},
+			want:       cotacao.ErrorResponse{Code: "FETCH_FAILED", Message: "Failed to fetch cotacao"},
+		},
+		{
+			name:       "save",
+			fetcher:    &cotacaotest.StubCotacaoFetcher{Bid: "5.43"},
+			repository: &cotacaotest.StubCotacaoRepository{Err: errors.New("disk full")},
+			want:       cotacao.ErrorResponse{Code: "SAVE_FAILED", Message: "Failed to save cotacao"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := cotacao.NewServer(tt.fetcher, tt.repository, cotacao.DefaultServerConfig())
+
+			rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var got cotacao.ErrorResponse
+			decoder := json.NewDecoder(rec.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&got); err != nil {
+				t.Fatalf("decoding the error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("error = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatestHandler(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+
+	rec := serve(t, h.Server.LatestHandler, http.MethodGet, "/cotacao/latest")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("empty table: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if body := decode(t, rec); body["code"] != "NOT_FOUND" {
+		t.Errorf("empty table: code = %v, want NOT_FOUND", body["code"])
+	}
+
+	seed(t, h, 3)
+	rec = serve(t, h.Server.LatestHandler, http.MethodGet, "/cotacao/latest")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("populated table: status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var latest cotacao.StoredCotacao
+	if err := json.Unmarshal(rec.Body.Bytes(), &latest); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	if latest.ID != 3 || latest.Bid != "5.0002" {
+		t.Errorf("latest = %+v, want row 3 with bid 5.0002", latest)
+	}
+}
+
+func TestLatestHandlerETag(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+	seed(t, h, 1)
+	get := func(handler http.Handler, ifNoneMatch string, gzip bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/cotacao/latest", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		if gzip {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	latest := http.HandlerFunc(h.Server.LatestHandler)
+
+	rec := get(latest, "", false)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag != `"1-5.0000"` {
+		t.Fatalf("first GET: status %d, ETag %q; want %d with \"1-5.0000\"", rec.Code, etag, http.StatusOK)
+	}
+
+	rec = get(latest, etag, false)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("matching If-None-Match: status %d with %d body bytes, want an empty %d", rec.Code, rec.Body.Len(), http.StatusNotModified)
+	}
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Errorf("304 ETag = %q, want %q", got, etag)
+	}
+
+	seed(t, h, 1)
+	rec = get(latest, etag, false)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("after a new quote: status %d, ETag %q; want %d with an ETag other than %q", rec.Code, rec.Header().Get("ETag"), http.StatusOK, etag)
+	}
+	etag = rec.Header().Get("ETag")
+
+	// Compressed, the representation gets a weak ETag that still revalidates.
+	compressed := cotacao.NewCompressor(0).Middleware(latest)
+	rec = get(compressed, "", true)
+	weak := rec.Header().Get("ETag")
+	if rec.Header().Get("Content-Encoding") != "gzip" || weak != "W/"+etag {
+		t.Fatalf("gzip GET: Content-Encoding %q, ETag %q; want gzip with W/%s", rec.Header().Get("Content-Encoding"), weak, etag)
+	}
+	if rec = get(compressed, weak, true); rec.Code != http.StatusNotModified {
+		t.Errorf("gzip If-None-Match %s: status %d, want %d", weak, rec.Code, http.StatusNotModified)
+	}
+	if rec = get(latest, weak, false); rec.Code != http.StatusNotModified {
+		t.Errorf("identity If-None-Match %s: status %d, want %d under weak comparison", weak, rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestCotacaoHandlerValidatesBid(t *testing.T) {
+	tests := []struct {
+		bid  string
+		want string
+	}{
+		{"5.43", "5.4300"},
+		{"-5.43", ""},
+		{"0", ""},
+		{"N/A", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.bid, func(t *testing.T) {
+			repository := &cotacaotest.StubCotacaoRepository{}
+			server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: tt.bid}, repository, cotacao.DefaultServerConfig())
+
+			rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+			body := decode(t, rec)
+
+			if tt.want != "" {
+				if rec.Code != http.StatusOK || body["bid"] != tt.want {
+					t.Errorf("got %d %v, want 200 with bid %s", rec.Code, body, tt.want)
+				}
+				if rows := repository.Rows(); len(rows) != 1 || rows[0].Bid != tt.want {
+					t.Errorf("stored %+v, want the normalized bid %s", rows, tt.want)
+				}
+				return
+			}
+			if rec.Code != http.StatusInternalServerError || body["code"] != "FETCH_FAILED" {
+				t.Errorf("got %d %v, want 500 FETCH_FAILED", rec.Code, body)
+			}
+			if n := repository.Calls("Save"); n != 0 {
+				t.Errorf("Save called %d times for an invalid bid", n)
+			}
+		})
+	}
+}
+
+func TestAverageHandler(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+
+	rec := serve(t, h.Server.AverageHandler, http.MethodGet, "/cotacao/average")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("empty table: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// Bids 5.0000 to 5.0009, newest last.
+	seed(t, h, 10)
+	tests := []struct {
+		target string
+		n      float64
+		avg    float64
+	}{
+		{"/cotacao/average?n=1", 1, 5.0009},
+		{"/cotacao/average?n=4", 4, 5.00075},
+		{"/cotacao/average?n=100", 100, 5.00045},
+	}
+	for _, tt := range tests {
+		rec := serve(t, h.Server.AverageHandler, http.MethodGet, tt.target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body %s", tt.target, rec.Code, http.StatusOK, rec.Body)
+		}
+		body := decode(t, rec)
+		if avg, _ := body["average"].(float64); body["n"] != tt.n || math.Abs(avg-tt.avg) > 1e-9 {
+			t.Errorf("%s: body = %v, want n %v with average %v", tt.target, body, tt.n, tt.avg)
+		}
+	}
+
+	for _, target := range []string{"/cotacao/average?n=abc", "/cotacao/average?n=0", "/cotacao/average?n=-3"} {
+		rec := serve(t, h.Server.AverageHandler, http.MethodGet, target)
+		if rec.Code != http.StatusBadRequest || decode(t, rec)["code"] != "INVALID_N" {
+			t.Errorf("%s: status = %d, want %d INVALID_N", target, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestQuoteStatsHandler(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+	seed(t, h, 10)
+
+	tests := []struct {
+		target        string
+		count         float64
+		min, max, avg float64
+	}{
+		{"/cotacao/stats", 10, 5.0000, 5.0009, 5.00045},
+		{"/cotacao/stats?since=2024-01-01T12:00:05Z", 5, 5.0005, 5.0009, 5.0007},
+		{"/cotacao/stats?since=2030-01-01T00:00:00Z", 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := serve(t, h.Server.QuoteStatsHandler, http.MethodGet, tt.target)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			body := decode(t, rec)
+			if body["count"] != tt.count {
+				t.Errorf("count = %v, want %v", body["count"], tt.count)
+			}
+			for key, want := range map[string]float64{"min": tt.min, "max": tt.max, "avg": tt.avg} {
+				if got, _ := body[key].(float64); math.Abs(got-want) > 1e-9 {
+					t.Errorf("%s = %v, want %v", key, body[key], want)
+				}
+			}
+		})
+	}
+
+	rec := serve(t, h.Server.QuoteStatsHandler, http.MethodGet, "/cotacao/stats?since=yesterday")
+	if rec.Code != http.StatusBadRequest || decode(t, rec)["code"] != "INVALID_SINCE" {
+		t.Errorf("?since=yesterday: status = %d, body %s; want %d INVALID_SINCE", rec.Code, rec.Body, http.StatusBadRequest)
+	}
+}
+
+func TestHistoryHandlerPaginates(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+	seed(t, h, 25)
+
+	seen := make(map[int64]bool)
+	var pages int
+	target := "/cotacao/history?limit=10"
+	for target != "" {
+		rec := serve(t, h.Server.HistoryHandler, http.MethodGet, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body %s", target, rec.Code, http.StatusOK, rec.Body)
+		}
+		var body struct {
+			Cotacoes []cotacao.StoredCotacao `json:"cotacoes"`
+			Next     *int64                  `json:"next"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding %q: %v", rec.Body, err)
+		}
+		pages++
+		for _, c := range body.Cotacoes {
+			if seen[c.ID] {
+				t.Errorf("page %d repeats id %d", pages, c.ID)
+			}
+			seen[c.ID] = true
+		}
+
+		target = ""
+		if body.Next != nil {
+			target = fmt.Sprintf("/cotacao/history?limit=10&after=%d", *body.Next)
+		}
+	}
+
+	if pages != 3 {
+		t.Errorf("walked %d pages, want 3 for 25 rows at 10 per page", pages)
+	}
+	for id := int64(1); id <= 25; id++ {
+		if !seen[id] {
+			t.Errorf("id %d missing from the pages", id)
+		}
+	}
+
+	rec := serve(t, h.Server.HistoryHandler, http.MethodGet, "/cotacao/history?after=abc")
+	if rec.Code != http.StatusBadRequest || decode(t, rec)["code"] != "INVALID_CURSOR" {
+		t.Errorf("after=abc: status = %d, want %d INVALID_CURSOR", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCotacaoHandlerServesFallbackAfterFailedRetries(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	tests := []struct {
+		policy cotacao.FallbackPolicy
+		status int
+		code   string
+	}{
+		{cotacao.FallbackStatic, http.StatusOK, ""},
+		{cotacao.FallbackError, http.StatusServiceUnavailable, "UPSTREAM_UNAVAILABLE"},
+	}
+	for _, tt := range tests {
+		fetcher := newFetcher(t, srv.URL+"/json/last/",
+			cotacao.WithRetry(1),
+			cotacao.WithFailureThreshold(10),
+			cotacao.WithFallbackPolicy(tt.policy),
+		)
+		repository := &cotacaotest.StubCotacaoRepository{}
+		server := cotacao.NewServer(fetcher, repository, cotacao.DefaultServerConfig())
+
+		rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+		body := decode(t, rec)
+
+		if rec.Code != tt.status {
+			t.Fatalf("policy %d: status = %d, want %d; body %v", tt.policy, rec.Code, tt.status, body)
+		}
+		if tt.code != "" {
+			if body["code"] != tt.code {
+				t.Errorf("policy %d: code = %v, want %s", tt.policy, body["code"], tt.code)
+			}
+			continue
+		}
+		if body["bid"] != "1.0000" || body["source"] != cotacao.SourceFallback {
+			t.Errorf("policy %d: body = %v, want the 1.0000 fallback", tt.policy, body)
+		}
+		if rows := repository.Rows(); len(rows) != 1 || rows[0].Source != cotacao.SourceFallback {
+			t.Errorf("policy %d: stored %+v, want the fallback marked as such", tt.policy, rows)
+		}
+	}
+}
+
+func TestCotacaoHandlerReportsFallbackSourceWhileOpen(t *testing.T) {
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	fetcher := newFetcher(t, srv.URL+"/json/last/",
+		cotacao.WithRetry(0),
+		cotacao.WithFailureThreshold(1),
+		cotacao.WithResetTime(time.Minute),
+	)
+	fetcher.Fetch(context.Background(), cotacao.DefaultPair)
+	if !fetcher.(cotacao.CircuitStateReporter).State().Open {
+		t.Fatal("circuit did not open")
+	}
+	repository := &cotacaotest.StubCotacaoRepository{}
+	server := cotacao.NewServer(fetcher, repository, cotacao.DefaultServerConfig())
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if body := decode(t, rec); body["source"] != cotacao.SourceFallback {
+		t.Errorf("source = %v, want %s", body["source"], cotacao.SourceFallback)
+	}
+	if rows := repository.Rows(); len(rows) != 1 || rows[0].Source != cotacao.SourceFallback {
+		t.Errorf("stored %+v, want one row with source %s", rows, cotacao.SourceFallback)
+	}
+}
+
+func TestCotacaoHandlerCircuitOpenRetryAfter(t *testing.T) {
+	url, _ := failingUpstream(t)
+	clock := cotacaotest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fetcher := newFetcher(t, url,
+		cotacao.WithClock(clock),
+		cotacao.WithRetry(0),
+		cotacao.WithFailureThreshold(1),
+		cotacao.WithResetTime(30*time.Second),
+		cotacao.WithFallbackPolicy(cotacao.FallbackError),
+	)
+	repository := &cotacaotest.StubCotacaoRepository{}
+	server := cotacao.NewServer(fetcher, repository, cotacao.DefaultServerConfig())
+
+	rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+	if rec.Code != http.StatusServiceUnavailable || decode(t, rec)["code"] != "UPSTREAM_UNAVAILABLE" {
+		t.Fatalf("failing fetch: status = %d, body %s; want %d UPSTREAM_UNAVAILABLE", rec.Code, rec.Body, http.StatusServiceUnavailable)
+	}
+
+	clock.Advance(10*time.Second + 500*time.Millisecond)
+	rec = serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+	}
+	// 19.5s of the 30s cooldown are left, rounded up to whole seconds.
+	if got := rec.Header().Get("Retry-After"); got != "20" {
+		t.Errorf("Retry-After = %q, want 20", got)
+	}
+	if body := decode(t, rec); body["code"] != "CIRCUIT_OPEN" || body["message"] == "" {
+		t.Errorf("body = %v, want code CIRCUIT_OPEN with a message", body)
+	}
+	if n := repository.Calls("Save"); n != 0 {
+		t.Errorf("Save called %d times without a bid to store", n)
+	}
+}
+
+// post runs a POST of body against handler and returns the recorded response.
+func post(t *testing.T, handler http.HandlerFunc, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	handler(rec, req)
+	return rec
+}
+
+func TestCotacaoHandlerInjection(t *testing.T) {
+	newServer := func(allow bool) (*cotacao.Server, *cotacaotest.StubCotacaoRepository) {
+		config := cotacao.DefaultServerConfig()
+		config.AllowInjection = allow
+		repository := &cotacaotest.StubCotacaoRepository{}
+		return cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{}, repository, config), repository
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		server, repository := newServer(true)
+		rec := post(t, server.CotacaoHandler, "/cotacao", `{"pair":"EUR-BRL","bid":"6.1"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusCreated, rec.Body)
+		}
+		var created cotacao.StoredCotacao
+		if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+			t.Fatalf("decoding %q: %v", rec.Body, err)
+		}
+		if created.ID == 0 || created.Pair != "EUR-BRL" || created.Bid != "6.1000" || created.Source != cotacao.SourceManual {
+			t.Errorf("created = %+v, want a manual EUR-BRL row with bid 6.1000", created)
+		}
+		if rows := repository.Rows(); len(rows) != 1 || rows[0].ID != created.ID {
+			t.Errorf("stored %+v, want the created row", rows)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		server, repository := newServer(true)
+		for body, code := range map[string]string{
+			`{"pair":`:                     "INVALID_BODY",
+			`{"pair":"usd","bid":"5"}`:     "INVALID_PAIR",
+			`{"pair":"USD-BRL","bid":"x"}`: "INVALID_BID",
+		} {
+			rec := post(t, server.CotacaoHandler, "/cotacao", body)
+			if rec.Code != http.StatusBadRequest || decode(t, rec)["code"] != code {
+				t.Errorf("%s: status = %d, want %d %s", body, rec.Code, http.StatusBadRequest, code)
+			}
+		}
+		if n := repository.Calls("Save"); n != 0 {
+			t.Errorf("Save called %d times for invalid input", n)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		server, repository := newServer(false)
+		rec := post(t, server.CotacaoHandler, "/cotacao", `{"pair":"USD-BRL","bid":"5.43"}`)
+		if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != http.MethodGet {
+			t.Errorf("status = %d with Allow %q, want %d allowing GET", rec.Code, rec.Header().Get("Allow"), http.StatusMethodNotAllowed)
+		}
+		if n := repository.Calls("Save"); n != 0 {
+			t.Errorf("Save called %d times with injection disabled", n)
+		}
+	})
+}
+
+func TestNormalizeBid(t *testing.T) {
+	tests := []struct {
+		bid   string
+		scale int32
+		want  string
+	}{
+		{"5.43", 4, "5.4300"},
+		{"5.4300", 4, "5.4300"},
+		{"5.439999", 4, "5.4400"},
+		{"5.439999", 2, "5.44"},
+		{"5.431249", 4, "5.4312"},
+		{"5.123456789012345", 8, "5.12345679"},
+	}
+	for _, tt := range tests {
+		got, err := cotacao.NormalizeBid(tt.bid, tt.scale)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeBid(%q, %d) = %q, %v; want %q", tt.bid, tt.scale, got, err, tt.want)
+		}
+	}
+
+	for _, bid := range []string{"", "abc", "0", "-1.5", "NaN"} {
+		if got, err := cotacao.NormalizeBid(bid, 4); !errors.Is(err, cotacao.ErrInvalidBid) {
+			t.Errorf("NormalizeBid(%q) = %q, %v; want %v", bid, got, err, cotacao.ErrInvalidBid)
+		}
+	}
+}
+
+func TestExportHandlerPagesByID(t *testing.T) {
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+	seed(t, h, 1201)
+
+	rec := serve(t, h.Handler.ServeHTTP, http.MethodGet, "/cotacao/export.csv")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != `attachment; filename="cotacoes.csv"` {
+		t.Errorf("Content-Disposition = %q, want the cotacoes.csv attachment", got)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 1202 {
+		t.Fatalf("got %d lines, want the header and 1201 rows", len(lines))
+	}
+	if lines[0] != "id,pair,bid,timestamp" {
+		t.Errorf("header = %q, want id,pair,bid,timestamp", lines[0])
+	}
+	for _, i := range []int{1, 500, 501, 1201} {
+		want := fmt.Sprintf("%d,%s,5.%04d,%s", i, cotacao.DefaultPair, i-1,
+			time.Date(2024, 1, 1, 12, 0, i-1, 0, time.UTC).Format(time.RFC3339))
+		if lines[i] != want {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+
+	repository := &cotacaotest.StubCotacaoRepository{}
+	items := make([]cotacao.StoredCotacao, 1000)
+	for i := range items {
+		items[i] = cotacao.StoredCotacao{Pair: cotacao.DefaultPair, Bid: "5.43", Source: cotacao.SourceLive, Timestamp: time.Now()}
+	}
+	if err := repository.SaveBatch(context.Background(), items); err != nil {
+		t.Fatalf("SaveBatch: %v", err)
+	}
+	server := cotacao.NewServer(&cotacaotest.StubCotacaoFetcher{Bid: "5.43"}, repository, cotacao.DefaultServerConfig())
+	serve(t, server.ExportHandler, http.MethodGet, "/cotacao/export.csv")
+	// Two full pages and the empty one that shows the table has ended.
+	if got := repository.Calls("Page"); got != 3 {
+		t.Errorf("Page called %d times for 1000 rows, want 3", got)
+	}
+}
+
+func TestCotacaoHandlerFreshBypassesCache(t *testing.T) {
+	upstream := &cotacaotest.StubCotacaoFetcher{Bid: "5.43"}
+	cache := cotacao.NewCachingCotacaoFetcher(upstream, time.Minute)
+	server := cotacao.NewServer(cache, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+
+	requests := []struct {
+		name         string
+		target       string
+		cacheControl string
+		calls        int
+	}{
+		{"first request", "/cotacao", "", 1},
+		{"cached", "/cotacao", "", 1},
+		{"fresh=true", "/cotacao?fresh=true", "", 2},
+		{"Cache-Control: no-cache", "/cotacao", "no-cache", 3},
+		{"cached after fresh", "/cotacao", "", 3},
+		{"fresh=false", "/cotacao?fresh=false", "", 3},
+	}
+	for _, tt := range requests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.cacheControl != "" {
+			req.Header.Set("Cache-Control", tt.cacheControl)
+		}
+		rec := httptest.NewRecorder()
+		server.CotacaoHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body %s", tt.name, rec.Code, http.StatusOK, rec.Body)
+		}
+		if got := upstream.Calls(); got != tt.calls {
+			t.Errorf("%s: %d upstream calls, want %d", tt.name, got, tt.calls)
+		}
+	}
+
+	if rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao?fresh=maybe"); rec.Code != http.StatusBadRequest {
+		t.Errorf("fresh=maybe: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestCotacaoHandlerStoresFetchLatency(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	srv := upstream(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		servePayload(cotacaotest.AwesomeAPIPayload)(w, r)
+	})
+	repository := cotacao.NewSQLiteCotacaoRepository(openSQLite(t))
+	server := cotacao.NewServer(newFetcher(t, srv.URL+"/json/last/"), repository, cotacao.DefaultServerConfig())
+
+	if rec := serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	rec := serve(t, server.HistoryHandler, http.MethodGet, "/cotacao/history")
+	var history struct {
+		Cotacoes []cotacao.StoredCotacao `json:"cotacoes"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &history); err != nil || len(history.Cotacoes) != 1 {
+		t.Fatalf("history = %s, %v; want one row", rec.Body, err)
+	}
+	// The upstream sleeps for delay, so the fetch cannot have been faster;
+	// the upper bound only rules out garbage such as a timestamp.
+	if ms := history.Cotacoes[0].LatencyMs; ms < delay.Milliseconds() || ms > 10_000 {
+		t.Errorf("latency_ms = %d, want at least %d", ms, delay.Milliseconds())
+	}
+}
+
+func TestHealthHandlerDeep(t *testing.T) {
+	healthy := upstream(t, servePayload(cotacaotest.AwesomeAPIPayload)).URL + "/json/last/"
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	unreachable := down.URL + "/json/last/"
+
+	tests := []struct {
+		name     string
+		fetcher  func(t *testing.T) cotacao.CotacaoFetcher
+		status   int
+		upstream string
+	}{
+		{"healthy upstream", func(t *testing.T) cotacao.CotacaoFetcher { return newFetcher(t, healthy) }, http.StatusOK, "ok"},
+		{"unreachable upstream", func(t *testing.T) cotacao.CotacaoFetcher { return newFetcher(t, unreachable) }, http.StatusServiceUnavailable, "unreachable"},
+		{"through wrappers", func(t *testing.T) cotacao.CotacaoFetcher {
+			return cotacao.NewCachingCotacaoFetcher(cotacao.NewLimitedCotacaoFetcher(newFetcher(t, unreachable), 1, true), time.Minute)
+		}, http.StatusServiceUnavailable, "unreachable"},
+		{"no probe", func(t *testing.T) cotacao.CotacaoFetcher { return &cotacaotest.StubCotacaoFetcher{Bid: "5.43"} }, http.StatusOK, "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := cotacao.NewServer(tt.fetcher(t), &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+
+			rec := serve(t, server.HealthHandler, http.MethodGet, "/health?deep=true")
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			body := decode(t, rec)
+			upstreamHealth, _ := body["upstream"].(map[string]any)
+			databaseHealth, _ := body["database"].(map[string]any)
+			if upstreamHealth["status"] != tt.upstream || databaseHealth["status"] != "ok" || body["circuit"] != "closed" {
+				t.Errorf("body = %v, want upstream %s, database ok and the circuit closed", body, tt.upstream)
+			}
+
+			shallow := decode(t, serve(t, server.HealthHandler, http.MethodGet, "/health"))
+			if _, ok := shallow["upstream"]; ok {
+				t.Errorf("/health without deep = %v, want no upstream check", shallow)
+			}
+		})
+	}
+}
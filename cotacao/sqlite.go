@@ -0,0 +1,175 @@
+package cotacao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type SQLiteCotacaoRepository struct {
+	db *sql.DB
+}
+
+func NewSQLiteCotacaoRepository(db *sql.DB) CotacaoRepository {
+	return &SQLiteCotacaoRepository{db: db}
+}
+
+func (r *SQLiteCotacaoRepository) Save(ctx context.Context, pair, bid, source string, fetchedAt time.Time, latency time.Duration) (id int64, err error) {
+	ctx, span := tracer.Start(ctx, "SQLiteCotacaoRepository.Save", trace.WithAttributes(attribute.String("cotacao.pair", pair), attribute.String("cotacao.source", source)))
+	defer func() { endSpan(span, err) }()
+
+	result, err := r.db.ExecContext(ctx, "INSERT INTO cotacao(pair, bid, source, timestamp, latency_ms) VALUES(?, ?, ?, ?, ?)", pair, bid, source, fetchTimestamp(fetchedAt), latency.Milliseconds())
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrSaveFailed, err)
+	}
+	if id, err = result.LastInsertId(); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrSaveFailed, err)
+	}
+	span.SetAttributes(attribute.Int64("db.row_id", id))
+	return id, nil
+}
+
+func (r *SQLiteCotacaoRepository) List(ctx context.Context, limit int) ([]StoredCotacao, error) {
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao ORDER BY id DESC LIMIT ?", limit)
+}
+
+func (r *SQLiteCotacaoRepository) ListAfter(ctx context.Context, afterID int64, limit int) ([]StoredCotacao, error) {
+	if afterID <= 0 {
+		return r.List(ctx, limit)
+	}
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE id < ? ORDER BY id DESC LIMIT ?", afterID, limit)
+}
+
+func (r *SQLiteCotacaoRepository) Latest(ctx context.Context) (StoredCotacao, error) {
+	var c StoredCotacao
+	err := r.db.QueryRowContext(ctx, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao ORDER BY id DESC LIMIT 1").
+		Scan(&c.ID, &c.Pair, &c.Bid, &c.Source, &c.Timestamp, &c.LatencyMs)
+	return c, err
+}
+
+func (r *SQLiteCotacaoRepository) LatestByPair(ctx context.Context, pair string) (StoredCotacao, error) {
+	var c StoredCotacao
+	err := r.db.QueryRowContext(ctx, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE pair = ? ORDER BY id DESC LIMIT 1", pair).
+		Scan(&c.ID, &c.Pair, &c.Bid, &c.Source, &c.Timestamp, &c.LatencyMs)
+	return c, err
+}
+
+func (r *SQLiteCotacaoRepository) Range(ctx context.Context, from, to time.Time, limit int) ([]StoredCotacao, error) {
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE timestamp BETWEEN ? AND ? ORDER BY timestamp, id LIMIT ?", from.UTC(), to.UTC(), limit)
+}
+
+func (r *SQLiteCotacaoRepository) SaveBatch(ctx context.Context, items []StoredCotacao) error {
+	return saveBatch(ctx, r.db, items, func(int) string { return "?" })
+}
+
+func (r *SQLiteCotacaoRepository) Prune(ctx context.Context, olderThan time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM cotacao WHERE timestamp < ?", olderThan.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *SQLiteCotacaoRepository) Average(ctx context.Context, n int) (float64, error) {
+	var avg sql.NullFloat64
+	err := r.db.QueryRowContext(ctx, "SELECT AVG(CAST(bid AS REAL)) FROM (SELECT bid FROM cotacao ORDER BY id DESC LIMIT ?)", n).Scan(&avg)
+	if err != nil {
+		return 0, err
+	}
+	if !avg.Valid {
+		return 0, sql.ErrNoRows
+	}
+	return avg.Float64, nil
+}
+
+func (r *SQLiteCotacaoRepository) Page(ctx context.Context, afterID int64, limit int) ([]StoredCotacao, error) {
+	return queryCotacoes(ctx, r.db, "SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao WHERE id > ? ORDER BY id LIMIT ?", afterID, limit)
+}
+
+func (r *SQLiteCotacaoRepository) Stats(ctx context.Context, since time.Time) (Stats, error) {
+	return queryStats(ctx, r.db, "SELECT COUNT(*), MIN(CAST(bid AS REAL)), MAX(CAST(bid AS REAL)), AVG(CAST(bid AS REAL)) FROM cotacao WHERE timestamp >= ?", since.UTC())
+}
+
+func (r *SQLiteCotacaoRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// sqlitePool serializes access through a single connection, since SQLite
+// allows one writer at a time and concurrent writers fail with "database is
+// locked".
+var sqlitePool = PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1}
+
+// sqliteMigrations evolve the original cotacao table. The column additions
+// tolerate databases that already got the column before migrations were
+// tracked.
+var sqliteMigrations = []migration{
+	{1, "create cotacao", execMigration(`CREATE TABLE IF NOT EXISTS cotacao (id INTEGER PRIMARY KEY AUTOINCREMENT, bid TEXT, timestamp DATETIME DEFAULT CURRENT_TIMESTAMP)`)},
+	{2, "add pair", sqliteAddColumn("pair", "TEXT NOT NULL DEFAULT 'USD-BRL'")},
+	{3, "add source", sqliteAddColumn("source", "TEXT NOT NULL DEFAULT 'live'")},
+	{4, "index pair", execMigration(`CREATE INDEX IF NOT EXISTS cotacao_pair_id ON cotacao (pair, id)`)},
+	{5, "add latency", sqliteAddColumn("latency_ms", "INTEGER NOT NULL DEFAULT 0")},
+}
+
+func sqliteAddColumn(name, definition string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		var exists int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info('cotacao') WHERE name = ?", name).Scan(&exists); err != nil {
+			return err
+		}
+		if exists > 0 {
+			return nil
+		}
+		_, err := tx.ExecContext(ctx, "ALTER TABLE cotacao ADD COLUMN "+name+" "+definition)
+		return err
+	}
+}
+
+// OpenSQLite opens the SQLite database at dsn in WAL mode and migrates the
+// schema. Use "file::memory:?cache=shared" for an in-memory database.
+func OpenSQLite(dsn string) (*sql.DB, error) {
+	return OpenSQLiteDriver("sqlite3", dsn)
+}
+
+// OpenSQLiteDriver is OpenSQLite through the database/sql driver registered
+// as driver, such as a go-sqlite3 driver registered with extensions or hooks.
+func OpenSQLiteDriver(driver, dsn string) (*sql.DB, error) {
+	db, err := openDB(driver, sqliteDSN(dsn))
+	if err != nil {
+		return nil, err
+	}
+	sqlitePool.apply(db)
+
+	if err := migrate(context.Background(), db, sqliteMigrations, func(int) string { return "?" }); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+
+	return db, nil
+}
+
+// sqliteDSN enables WAL journaling and a busy timeout unless dsn already sets
+// them.
+func sqliteDSN(dsn string) string {
+	var params []string
+	if !strings.Contains(dsn, "_journal_mode=") {
+		params = append(params, "_journal_mode=WAL")
+	}
+	if !strings.Contains(dsn, "_busy_timeout=") {
+		params = append(params, "_busy_timeout=5000")
+	}
+	if len(params) == 0 {
+		return dsn
+	}
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	return dsn + separator + strings.Join(params, "&")
+}
@@ -0,0 +1,274 @@
+package cotacao_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+)
+
+// openSQLite opens a migrated in-memory database private to the test.
+func openSQLite(t testing.TB) *sql.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := cotacao.OpenSQLite("file:" + name + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteSaveWrapsErrSaveFailed(t *testing.T) {
+	db := openSQLite(t)
+	repository := cotacao.NewSQLiteCotacaoRepository(db)
+	db.Close()
+
+	_, err := repository.Save(context.Background(), cotacao.DefaultPair, "5.4321", cotacao.SourceLive, time.Now(), 0)
+
+	if !errors.Is(err, cotacao.ErrSaveFailed) {
+		t.Errorf("err = %v, want %v", err, cotacao.ErrSaveFailed)
+	}
+	if !strings.Contains(err.Error(), "database is closed") {
+		t.Errorf("err = %v, want the driver error kept", err)
+	}
+}
+
+func TestOpenUnregisteredDriver(t *testing.T) {
+	tests := []struct {
+		name string
+		open func() (*sql.DB, error)
+	}{
+		{"OpenSQLiteDriver", func() (*sql.DB, error) {
+			return cotacao.OpenSQLiteDriver("sqlite-missing", "file::memory:")
+		}},
+		{"OpenPostgresDriver", func() (*sql.DB, error) {
+			return cotacao.OpenPostgresDriver("postgres-missing", "postgres://localhost/cotacao", cotacao.DefaultPoolConfig())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := tt.open()
+			if db != nil {
+				db.Close()
+				t.Error("got a *sql.DB for an unregistered driver")
+			}
+			if !errors.Is(err, cotacao.ErrDriverNotRegistered) {
+				t.Fatalf("err = %v, want %v", err, cotacao.ErrDriverNotRegistered)
+			}
+			if !strings.Contains(err.Error(), "-missing") || !strings.Contains(err.Error(), "sqlite3") {
+				t.Errorf("err = %v, want it to name the driver and list the registered ones", err)
+			}
+		})
+	}
+}
+
+func TestSQLiteSaveStoresFetchTime(t *testing.T) {
+	repository := cotacao.NewSQLiteCotacaoRepository(openSQLite(t))
+	ctx := context.Background()
+	fetchedAt := time.Date(2024, 3, 4, 5, 6, 7, 0, time.FixedZone("BRT", -3*60*60))
+
+	if _, err := repository.Save(ctx, cotacao.DefaultPair, "5.4321", cotacao.SourceLive, fetchedAt, 0); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	stored, err := repository.Latest(ctx)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if !stored.Timestamp.Equal(fetchedAt) || stored.Timestamp.Location() != time.UTC {
+		t.Errorf("timestamp = %s, want %s in UTC", stored.Timestamp, fetchedAt.UTC())
+	}
+}
+
+func TestSQLiteSaveDefaultsZeroFetchTime(t *testing.T) {
+	repository := cotacao.NewSQLiteCotacaoRepository(openSQLite(t))
+	ctx := context.Background()
+
+	before := time.Now().Add(-time.Second)
+	if _, err := repository.Save(ctx, cotacao.DefaultPair, "5.4321", cotacao.SourceLive, time.Time{}, 0); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	stored, err := repository.Latest(ctx)
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if stored.Timestamp.Before(before) || stored.Timestamp.After(time.Now().Add(time.Second)) {
+		t.Errorf("timestamp = %s, want about now for a zero fetch time", stored.Timestamp)
+	}
+}
+
+func TestOpenSQLiteInMemoryMigrates(t *testing.T) {
+	db := openSQLite(t)
+
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'cotacao'").Scan(&name)
+	if err != nil {
+		t.Fatalf("looking up the cotacao table: %v", err)
+	}
+	columns, err := db.Query("SELECT id, pair, bid, source, timestamp, latency_ms FROM cotacao")
+	if err != nil {
+		t.Fatalf("cotacao table lacks the migrated columns: %v", err)
+	}
+	columns.Close()
+}
+
+// quotes builds n USD-BRL quotes a second apart.
+func quotes(n int) []cotacao.StoredCotacao {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := make([]cotacao.StoredCotacao, n)
+	for i := range items {
+		items[i] = cotacao.StoredCotacao{
+			Pair:      cotacao.DefaultPair,
+			Bid:       "5.4321",
+			Source:    cotacao.SourceLive,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}
+	}
+	return items
+}
+
+func TestSQLiteSaveBatch(t *testing.T) {
+	db := openSQLite(t)
+	repository := cotacao.NewSQLiteCotacaoRepository(db)
+
+	if err := repository.SaveBatch(context.Background(), quotes(1000)); err != nil {
+		t.Fatalf("SaveBatch: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cotacao").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1000 {
+		t.Errorf("stored %d rows, want 1000", count)
+	}
+}
+
+func TestSQLiteSaveBatchRollsBack(t *testing.T) {
+	db := openSQLite(t)
+	repository := cotacao.NewSQLiteCotacaoRepository(db)
+	_, err := db.Exec(`CREATE TRIGGER reject_boom BEFORE INSERT ON cotacao WHEN NEW.bid = 'boom'
+		BEGIN SELECT RAISE(ABORT, 'boom'); END`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bad row sits in a later chunk, after earlier ones were inserted.
+	items := quotes(1000)
+	items[700].Bid = "boom"
+
+	if err := repository.SaveBatch(context.Background(), items); err == nil {
+		t.Fatal("SaveBatch succeeded despite a rejected row")
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cotacao").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d rows left behind by a failed batch, want 0", count)
+	}
+}
+
+func BenchmarkSQLiteSaveBatch(b *testing.B) {
+	repository := cotacao.NewSQLiteCotacaoRepository(openSQLite(b))
+	items := quotes(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := repository.SaveBatch(context.Background(), items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSQLiteSavePerRow(b *testing.B) {
+	repository := cotacao.NewSQLiteCotacaoRepository(openSQLite(b))
+	items := quotes(1000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, item := range items {
+			if _, err := repository.Save(context.Background(), item.Pair, item.Bid, item.Source, item.Timestamp, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSQLitePrune(t *testing.T) {
+	repository := cotacao.NewSQLiteCotacaoRepository(openSQLite(t))
+	ctx := context.Background()
+	// One quote per day from Jan 1 to Jan 10.
+	items := quotes(10)
+	for i := range items {
+		items[i].Timestamp = time.Date(2024, 1, 1+i, 12, 0, 0, 0, time.UTC)
+	}
+	if err := repository.SaveBatch(ctx, items); err != nil {
+		t.Fatalf("SaveBatch: %v", err)
+	}
+
+	removed, err := repository.Prune(ctx, time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("Prune removed %d rows, want the 3 before Jan 4", removed)
+	}
+
+	remaining, err := repository.List(ctx, 100)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(remaining) != 7 {
+		t.Fatalf("%d rows remain, want 7", len(remaining))
+	}
+	if oldest := remaining[len(remaining)-1].Timestamp; !oldest.Equal(time.Date(2024, 1, 4, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("oldest remaining quote is from %s, want Jan 4", oldest)
+	}
+}
+
+func TestSQLiteConcurrentSaves(t *testing.T) {
+	db, err := cotacao.OpenSQLite(filepath.Join(t.TempDir(), "cotacao.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q, %v; want wal", mode, err)
+	}
+	repository := cotacao.NewSQLiteCotacaoRepository(db)
+
+	const saves = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, saves)
+	for i := 0; i < saves; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repository.Save(context.Background(), cotacao.DefaultPair, "5.4321", cotacao.SourceLive, time.Now(), 0)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent Save: %v", err)
+		}
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cotacao").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != saves {
+		t.Errorf("stored %d rows, want %d", count, saves)
+	}
+}
@@ -0,0 +1,80 @@
+package cotacao
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// serverStats counts CotacaoHandler fetches for StatsHandler.
+type serverStats struct {
+	requests   atomic.Int64
+	successes  atomic.Int64
+	failures   atomic.Int64
+	fallbacks  atomic.Int64
+	fetchNanos atomic.Int64
+}
+
+func (st *serverStats) recordFetch(result FetchResult, err error, latency time.Duration) {
+	st.fetchNanos.Add(int64(latency))
+	// A fallback is served without an error but still means upstream failed.
+	if err != nil || result.Source == SourceFallback {
+		st.failures.Add(1)
+	} else {
+		st.successes.Add(1)
+	}
+	if result.Source == SourceFallback {
+		st.fallbacks.Add(1)
+	}
+}
+
+type statsResponse struct {
+	Requests              int64   `json:"requests"`
+	Successes             int64   `json:"successes"`
+	Failures              int64   `json:"failures"`
+	Fallbacks             int64   `json:"fallbacks"`
+	Circuit               string  `json:"circuit"`
+	AverageFetchLatencyMs float64 `json:"average_fetch_latency_ms"`
+}
+
+// snapshot reads the counters, zeroing them when reset is set. Counters are
+// read one by one, so a snapshot taken under load may mix two instants.
+func (st *serverStats) snapshot(reset bool) statsResponse {
+	load := func(v *atomic.Int64) int64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+
+	response := statsResponse{
+		Requests:  load(&st.requests),
+		Successes: load(&st.successes),
+		Failures:  load(&st.failures),
+		Fallbacks: load(&st.fallbacks),
+	}
+	nanos := load(&st.fetchNanos)
+	if fetches := response.Successes + response.Failures; fetches > 0 {
+		response.AverageFetchLatencyMs = float64(nanos) / float64(fetches) / float64(time.Millisecond)
+	}
+	return response
+}
+
+// StatsHandler reports the /cotacao counters as JSON; ?reset=true zeroes them
+// after reading.
+func (s *Server) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	reset := false
+	if v := r.URL.Query().Get("reset"); v != "" {
+		parsed, err := strconv.ParseBool(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "INVALID_RESET", "Invalid reset, expected true or false")
+			return
+		}
+		reset = parsed
+	}
+
+	response := s.stats.snapshot(reset)
+	response.Circuit = s.circuitState().String()
+	writeJSON(w, http.StatusOK, response)
+}
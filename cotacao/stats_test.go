@@ -0,0 +1,67 @@
+package cotacao_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/pietronirod/client-server-api/cotacao"
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+)
+
+func TestStatsHandlerCountsAndResets(t *testing.T) {
+	fetcher := &cotacaotest.StubCotacaoFetcher{Bid: "5.43"}
+	server := cotacao.NewServer(fetcher, &cotacaotest.StubCotacaoRepository{}, cotacao.DefaultServerConfig())
+	fetch := func(bid, source string, err error) {
+		fetcher.Bid, fetcher.Source, fetcher.Err = bid, source, err
+		serve(t, server.CotacaoHandler, http.MethodGet, "/cotacao")
+	}
+	stats := func(target string) map[string]any {
+		rec := serve(t, server.StatsHandler, http.MethodGet, target)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d; body %s", target, rec.Code, http.StatusOK, rec.Body)
+		}
+		return decode(t, rec)
+	}
+
+	fetch("5.43", cotacao.SourceLive, nil)
+	fetch("5.44", cotacao.SourceLive, nil)
+	fetch("", "", errors.New("upstream exploded"))
+	fetch("1.00", cotacao.SourceFallback, nil)
+
+	body := stats("/stats")
+	want := map[string]float64{"requests": 4, "successes": 2, "failures": 2, "fallbacks": 1}
+	for key, n := range want {
+		if body[key] != n {
+			t.Errorf("%s = %v, want %v", key, body[key], n)
+		}
+	}
+	if body["circuit"] == "" {
+		t.Error("circuit is empty")
+	}
+	if _, ok := body["average_fetch_latency_ms"].(float64); !ok {
+		t.Errorf("average_fetch_latency_ms = %v, want a number", body["average_fetch_latency_ms"])
+	}
+
+	if body := stats("/stats?reset=true"); body["requests"] != 4.0 {
+		t.Errorf("requests read by the reset = %v, want the 4 counted before it", body["requests"])
+	}
+	body = stats("/stats")
+	for key := range want {
+		if body[key] != 0.0 {
+			t.Errorf("%s after reset = %v, want 0", key, body[key])
+		}
+	}
+	if body["average_fetch_latency_ms"] != 0.0 {
+		t.Errorf("average_fetch_latency_ms after reset = %v, want 0", body["average_fetch_latency_ms"])
+	}
+
+	fetch("5.45", cotacao.SourceLive, nil)
+	if body := stats("/stats"); body["requests"] != 1.0 || body["successes"] != 1.0 {
+		t.Errorf("after one more fetch: requests %v, successes %v; want 1 and 1", body["requests"], body["successes"])
+	}
+
+	if rec := serve(t, server.StatsHandler, http.MethodGet, "/stats?reset=maybe"); rec.Code != http.StatusBadRequest || decode(t, rec)["code"] != "INVALID_RESET" {
+		t.Errorf("?reset=maybe: status = %d, body %s; want %d INVALID_RESET", rec.Code, rec.Body, http.StatusBadRequest)
+	}
+}
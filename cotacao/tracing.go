@@ -0,0 +1,19 @@
+package cotacao
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer uses the global TracerProvider, so spans are exported once the
+// embedding program installs one with otel.SetTracerProvider.
+var tracer = otel.Tracer("github.com/pietronirod/client-server-api/cotacao")
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,74 @@
+package cotacao_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pietronirod/client-server-api/cotacao/cotacaotest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttr returns the value of attribute key on span, or an invalid value.
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestCotacaoHandlerSpanTree(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	// The package tracer delegates to the first provider installed globally.
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+	h := newHarness(t, cotacaotest.AwesomeAPIPayload)
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req := httptest.NewRequest(http.MethodGet, "/cotacao", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	rec := httptest.NewRecorder()
+	h.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	handler, fetch, save := spans["Server.CotacaoHandler"], spans["ApiCotacaoFetcher.Fetch"], spans["SQLiteCotacaoRepository.Save"]
+	if handler == nil || fetch == nil || save == nil {
+		t.Fatalf("recorded spans %v, want the handler, fetch and save spans", spans)
+	}
+
+	if got := handler.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("handler trace ID = %s, want the inbound %s", got, traceID)
+	}
+	if got := handler.Parent().SpanID().String(); got != parentID || !handler.Parent().IsRemote() {
+		t.Errorf("handler parent = %s, want the remote inbound span %s", got, parentID)
+	}
+	for _, child := range []sdktrace.ReadOnlySpan{fetch, save} {
+		if child.Parent().SpanID() != handler.SpanContext().SpanID() {
+			t.Errorf("%s parent = %s, want the handler span %s", child.Name(), child.Parent().SpanID(), handler.SpanContext().SpanID())
+		}
+	}
+
+	if got := spanAttr(fetch, "fetch.attempt").AsInt64(); got != 1 {
+		t.Errorf("fetch.attempt = %d, want 1", got)
+	}
+	if got := spanAttr(fetch, "circuit.state").AsString(); got != "closed" {
+		t.Errorf("circuit.state = %q, want closed", got)
+	}
+	if got := spanAttr(save, "db.row_id").AsInt64(); got != 1 {
+		t.Errorf("db.row_id = %d, want 1", got)
+	}
+}